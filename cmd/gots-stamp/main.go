@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
 	"os"
@@ -24,7 +25,9 @@ func main() {
 		log.Fatalf("error creating output file: %v", err)
 	}
 
-	dts, err := opentimestamps.CreateDetachedTimestampForFile(path, cal)
+	dts, err := opentimestamps.CreateDetachedTimestampForFile(
+		context.Background(), path, cal,
+	)
 	if err != nil {
 		log.Fatalf(
 			"error creating detached timestamp for %s: %v",
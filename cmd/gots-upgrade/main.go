@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
@@ -27,7 +28,7 @@ func main() {
 			"#%2d: upgrade %v\n     %x\n    ",
 			n, pts.PendingAttestation, pts.Timestamp.Message,
 		)
-		u, err := pts.Upgrade()
+		u, err := pts.Upgrade(context.Background())
 		if err != nil {
 			fmt.Printf(" error %v", err)
 		} else {
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
@@ -42,7 +43,7 @@ func main() {
 
 	verifier := client.NewBitcoinAttestationVerifier(btcConn)
 
-	ts, err := verifier.Verify(dts.Timestamp)
+	ts, err := verifier.Verify(context.Background(), dts.Timestamp)
 	if err != nil {
 		log.Fatalf("error verifying timestamp: %v", err)
 	}
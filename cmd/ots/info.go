@@ -0,0 +1,29 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/nginthfs/go-opentimestamps/opentimestamps"
+)
+
+func runInfo(args []string) error {
+	flags := flag.NewFlagSet("info", flag.ExitOnError)
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("expected one file, got %d", flags.NArg())
+	}
+	path := flags.Arg(0)
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	dts, err := opentimestamps.ReadDetachedTimestampFile(f)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %v", path, err)
+	}
+	fmt.Print(dts.Dump())
+	return nil
+}
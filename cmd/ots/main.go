@@ -0,0 +1,62 @@
+// Command ots creates, inspects, upgrades and verifies OpenTimestamps proofs.
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// A command is a subcommand of ots. run gets the arguments following the
+// subcommand name.
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands = []command{
+	{"info", "info <file.ots>", runInfo},
+	{"stamp", "stamp [-f] [-calendar url]... [-digest hex | <file>]", runStamp},
+	{"verify", "verify [-backend name] <file.ots> [original]", runVerify},
+	{"upgrade", "upgrade <file.ots>", runUpgrade},
+}
+
+// exitError is returned by commands that exit with a specific status code.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e exitError) Error() string {
+	return e.err.Error()
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: ots <command> [arguments]")
+	fmt.Fprintln(os.Stderr, "commands:")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %s\n", c.usage)
+	}
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	for _, c := range commands {
+		if c.name != os.Args[1] {
+			continue
+		}
+		err := c.run(os.Args[2:])
+		if err == nil {
+			return
+		}
+		fmt.Fprintf(os.Stderr, "ots %s: %v\n", c.name, err)
+		if e, ok := err.(exitError); ok {
+			os.Exit(e.code)
+		}
+		os.Exit(1)
+	}
+	usage()
+}
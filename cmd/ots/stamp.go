@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/nginthfs/go-opentimestamps/opentimestamps"
+)
+
+// stampNonceSize is the length of the random nonce appended to the digest
+// before it is submitted, so calendars don't learn the digest itself.
+const stampNonceSize = 16
+
+// defaultMinResponses is the number of calendars that need to respond unless
+// fewer are used.
+const defaultMinResponses = 2
+
+// stampMinResponses returns the default of -m for n calendars given with
+// -calendar, or the default calendars if n is 0.
+func stampMinResponses(n int) int {
+	if n == 0 {
+		n = len(opentimestamps.DefaultCalendars)
+	}
+	if n < defaultMinResponses {
+		return n
+	}
+	return defaultMinResponses
+}
+
+// flagSet reports whether the flag called name was given.
+func flagSet(flags *flag.FlagSet, name string) bool {
+	set := false
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// stringList is a flag that can be given multiple times.
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+func runStamp(args []string) error {
+	flags := flag.NewFlagSet("stamp", flag.ExitOnError)
+	var calendars stringList
+	flags.Var(&calendars, "calendar", "calendar url, can be repeated")
+	digestHex := flags.String(
+		"digest", "", "stamp this hex sha256 digest instead of a file",
+	)
+	minResponses := flags.Int(
+		"m", defaultMinResponses,
+		"minimum number of calendars that need to respond "+
+			"(at most the number of calendars by default)",
+	)
+	timeout := flags.Duration("timeout", 10*time.Second, "submit timeout")
+	out := flags.String("o", "", "output file, <file>.ots by default")
+	force := flags.Bool("f", false, "overwrite an existing output file")
+	flags.Parse(args)
+	if !flagSet(flags, "m") {
+		*minResponses = stampMinResponses(len(calendars))
+	}
+
+	var digest []byte
+	outPath := *out
+	if *digestHex != "" {
+		if flags.NArg() != 0 {
+			return fmt.Errorf("-digest does not take a file")
+		}
+		var err error
+		if digest, err = hex.DecodeString(*digestHex); err != nil {
+			return fmt.Errorf("invalid digest: %v", err)
+		}
+		if len(digest) != sha256.Size {
+			return fmt.Errorf(
+				"invalid digest: expected %d bytes, got %d",
+				sha256.Size, len(digest),
+			)
+		}
+		if outPath == "" {
+			outPath = *digestHex + ".ots"
+		}
+	} else {
+		if flags.NArg() != 1 {
+			return fmt.Errorf("expected one file, got %d", flags.NArg())
+		}
+		ts, err := opentimestamps.NewTimestampFromFile(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		digest = ts.Message
+		if outPath == "" {
+			outPath = flags.Arg(0) + ".ots"
+		}
+	}
+
+	if _, err := os.Lstat(outPath); err == nil && !*force {
+		return fmt.Errorf("%s already exists, use -f to overwrite", outPath)
+	}
+
+	nonce := make([]byte, stampNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	commitment := sha256.Sum256(append(append([]byte{}, digest...), nonce...))
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	calendarTs, err := opentimestamps.SubmitToCalendars(
+		ctx, commitment[:], calendars, *minResponses,
+	)
+	if err != nil {
+		return err
+	}
+	ts, err := opentimestamps.NewBuilder(digest).
+		Append(nonce).
+		SHA256().
+		Merge(calendarTs).
+		Build()
+	if err != nil {
+		return err
+	}
+	dts, err := opentimestamps.NewDetachedTimestamp(
+		*opentimestamps.OpSHA256, digest, ts,
+	)
+	if err != nil {
+		return err
+	}
+	// O_EXCL also catches a file created while the calendars were asked
+	mode := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if *force {
+		mode = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	f, err := os.OpenFile(outPath, mode, 0644)
+	if err != nil {
+		return err
+	}
+	if err := opentimestamps.WriteDetachedTimestampFile(f, dts); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("wrote %s\n", outPath)
+	return nil
+}
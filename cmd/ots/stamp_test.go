@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nginthfs/go-opentimestamps/opentimestamps"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newStampCalendar returns a calendar that answers every submission with a
+// pending attestation for itself and records the submitted commitments.
+func newStampCalendar(t *testing.T) (*httptest.Server, *[][]byte) {
+	var submitted [][]byte
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			body, err := ioutil.ReadAll(r.Body)
+			assert.NoError(t, err)
+			submitted = append(submitted, body)
+			ts := &opentimestamps.Timestamp{Message: body}
+			ts.Attestations = []opentimestamps.Attestation{
+				opentimestamps.NewPendingAttestation(server.URL),
+			}
+			assert.NoError(t, ts.Serialize(w))
+		},
+	))
+	return server, &submitted
+}
+
+func TestStampMinResponses(t *testing.T) {
+	assert.Equal(t, 1, stampMinResponses(1))
+	assert.Equal(t, 2, stampMinResponses(2))
+	assert.Equal(t, 2, stampMinResponses(5))
+	assert.Equal(t, 2, stampMinResponses(0))
+
+	defaults := opentimestamps.DefaultCalendars
+	defer func() { opentimestamps.DefaultCalendars = defaults }()
+	opentimestamps.DefaultCalendars = defaults[:1]
+	assert.Equal(t, 1, stampMinResponses(0))
+}
+
+func TestRunStamp(t *testing.T) {
+	server, submitted := newStampCalendar(t)
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "ots-stamp")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	digest := sha256.Sum256([]byte("stamp"))
+	out := filepath.Join(dir, "digest.ots")
+	args := []string{
+		"-calendar", server.URL, "-digest", hex.EncodeToString(digest[:]),
+		"-o", out,
+	}
+	require.NoError(t, runStamp(args))
+
+	// the calendar only sees the digest behind a nonce
+	require.Len(t, *submitted, 1)
+	assert.Len(t, (*submitted)[0], sha256.Size)
+	assert.False(t, bytes.Equal(digest[:], (*submitted)[0]))
+
+	dts, err := opentimestamps.NewDetachedTimestampFromPath(out)
+	require.NoError(t, err)
+	assert.Equal(t, digest[:], dts.FileHash)
+	assert.Equal(t, []string{server.URL}, dts.Timestamp.PendingURIs())
+	var paths [][]opentimestamps.Operation
+	dts.Timestamp.WalkAttestations(func(
+		path []opentimestamps.Operation, _ opentimestamps.Attestation,
+	) {
+		paths = append(paths, path)
+	})
+	require.Len(t, paths, 1)
+	commitment, err := opentimestamps.ComputeResult(digest[:], paths[0])
+	require.NoError(t, err)
+	assert.Equal(t, (*submitted)[0], commitment)
+
+	// existing proofs are only replaced with -f
+	assert.Error(t, runStamp(args))
+	assert.Len(t, *submitted, 1)
+	require.NoError(t, runStamp(append([]string{"-f"}, args...)))
+	assert.Len(t, *submitted, 2)
+
+	// a single calendar can't satisfy an explicit -m 2
+	assert.Error(t, runStamp(append([]string{"-f", "-m", "2"}, args...)))
+
+	for _, digestHex := range []string{"abcd", hex.EncodeToString(
+		append(digest[:], 0x00),
+	)} {
+		err := runStamp([]string{
+			"-calendar", server.URL, "-digest", digestHex,
+			"-o", filepath.Join(dir, "invalid.ots"),
+		})
+		assert.Error(t, err, digestHex)
+	}
+	assert.Len(t, *submitted, 2)
+}
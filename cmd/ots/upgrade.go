@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/nginthfs/go-opentimestamps/opentimestamps"
+)
+
+func runUpgrade(args []string) error {
+	flags := flag.NewFlagSet("upgrade", flag.ExitOnError)
+	timeout := flags.Duration("timeout", 30*time.Second, "upgrade timeout")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("expected one file, got %d", flags.NArg())
+	}
+	path := flags.Arg(0)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	dts, err := opentimestamps.ReadDetachedTimestampFile(
+		bytes.NewBuffer(data),
+	)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %v", path, err)
+	}
+	if dts.Timestamp.Status() == opentimestamps.StatusComplete {
+		fmt.Println("timestamp is complete")
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	for _, pts := range opentimestamps.PendingTimestamps(dts.Timestamp) {
+		uri := pts.PendingAttestation.URI()
+		upgraded, err := pts.Upgrade(ctx)
+		if errors.Is(err, opentimestamps.ErrCommitmentNotFound) {
+			fmt.Printf("%s: not confirmed yet\n", uri)
+			continue
+		}
+		if err != nil {
+			fmt.Printf("%s: error %v\n", uri, err)
+			continue
+		}
+		if err := pts.Timestamp.Merge(upgraded); err != nil {
+			return err
+		}
+		fmt.Printf("%s: upgraded\n", uri)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := opentimestamps.WriteDetachedTimestampFile(buf, dts); err != nil {
+		return err
+	}
+	if bytes.Equal(buf.Bytes(), data) {
+		fmt.Println("timestamp not changed")
+		return nil
+	}
+	if err := ioutil.WriteFile(path+".bak", data, 0644); err != nil {
+		return fmt.Errorf("error writing backup: %v", err)
+	}
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	if dts.Timestamp.Status() != opentimestamps.StatusComplete {
+		fmt.Println("timestamp is still pending")
+	}
+	return nil
+}
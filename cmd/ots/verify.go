@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/nginthfs/go-opentimestamps/opentimestamps"
+	"github.com/nginthfs/go-opentimestamps/opentimestamps/client"
+)
+
+// exitPending is the exit code of verify if the timestamp has to be upgraded
+// first.
+const exitPending = 3
+
+func runVerify(args []string) error {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	backendName := flags.String("backend", "esplora", "bitcoind or esplora")
+	networkName := flags.String(
+		"network", "mainnet", "mainnet, testnet or signet",
+	)
+	bitcoindURL := flags.String(
+		"bitcoind-url", "",
+		"bitcoind rpc url (default: localhost port of -network)",
+	)
+	bitcoindUser := flags.String("bitcoind-user", "", "bitcoind rpc user")
+	bitcoindPass := flags.String("bitcoind-pass", "", "bitcoind rpc password")
+	esploraURL := flags.String(
+		"esplora-url", "",
+		"esplora api url (default: public api for -network)",
+	)
+	timeout := flags.Duration("timeout", 30*time.Second, "verify timeout")
+	flags.Parse(args)
+	if flags.NArg() < 1 || flags.NArg() > 2 {
+		return fmt.Errorf("expected <file.ots> [original-file]")
+	}
+
+	network, err := parseNetwork(*networkName)
+	if err != nil {
+		return err
+	}
+	var backend opentimestamps.VerificationBackend
+	switch *backendName {
+	case "bitcoind":
+		backend = &client.BitcoindBackend{
+			URL:      *bitcoindURL,
+			User:     *bitcoindUser,
+			Password: *bitcoindPass,
+			Network:  network,
+		}
+	case "esplora":
+		backend = &client.EsploraBackend{
+			URL: *esploraURL, Network: network,
+		}
+	default:
+		return fmt.Errorf("unknown backend %q", *backendName)
+	}
+
+	path := flags.Arg(0)
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	dts, err := opentimestamps.ReadDetachedTimestampFile(f)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %v", path, err)
+	}
+
+	original := strings.TrimSuffix(path, ".ots")
+	if flags.NArg() == 2 {
+		original = flags.Arg(1)
+	}
+	if err := checkFileHash(dts, original); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	return verifyBitcoin(ctx, dts.Timestamp, backend)
+}
+
+// parseNetwork returns the network called name.
+func parseNetwork(name string) (opentimestamps.Network, error) {
+	for _, n := range []opentimestamps.Network{
+		opentimestamps.NetworkMainnet,
+		opentimestamps.NetworkTestnet,
+		opentimestamps.NetworkSignet,
+	} {
+		if n.String() == name {
+			return n, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown network %q", name)
+}
+
+// checkFileHash returns an error if the file at path doesn't have the digest
+// dts was created for.
+func checkFileHash(dts *opentimestamps.DetachedTimestamp, path string) error {
+	ok, err := dts.MatchesFile(path)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf(
+			"%s does not match the timestamp digest %x",
+			path, dts.FileHash,
+		)
+	}
+	return nil
+}
+
+// verifyBitcoin prints the earliest bitcoin block that attests ts. Blocks on
+// networks other than mainnet are marked as such. Attestations that could not
+// be verified are reported by the exit code: 1 if a block didn't match and
+// exitPending if ts still has to be upgraded.
+func verifyBitcoin(
+	ctx context.Context, ts *opentimestamps.Timestamp,
+	backend opentimestamps.VerificationBackend,
+) error {
+	results, err := ts.VerifyAll(ctx, backend)
+	if err != nil {
+		return err
+	}
+	var earliest *opentimestamps.VerificationResult
+	var lastErr error
+	pending := false
+	for i, r := range results {
+		switch r.Status {
+		case opentimestamps.VerificationConfirmed:
+			if earliest == nil || r.Time.Before(earliest.Time) {
+				earliest = &results[i]
+			}
+		case opentimestamps.VerificationFailed:
+			lastErr = r.Err
+		case opentimestamps.VerificationPending:
+			pending = true
+		}
+	}
+	if earliest != nil {
+		chain := "Bitcoin"
+		if earliest.Network != opentimestamps.NetworkMainnet {
+			chain += " " + earliest.Network.String()
+		}
+		fmt.Printf(
+			"Success! %s block %d attests existence as of %s\n",
+			chain, earliest.Height, earliest.Time.Format(time.RFC3339),
+		)
+		// shown in the byte order used by block explorers
+		fmt.Printf(
+			"Merkle root %s\n",
+			opentimestamps.ReverseHex(earliest.MerkleRoot),
+		)
+		return nil
+	}
+	if lastErr != nil {
+		return lastErr
+	}
+	if pending {
+		return exitError{exitPending, errors.New(
+			"timestamp is pending, run ots upgrade first",
+		)}
+	}
+	return fmt.Errorf("no bitcoin attestation found")
+}
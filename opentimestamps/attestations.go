@@ -3,23 +3,57 @@ package opentimestamps
 import (
 	"bytes"
 	"fmt"
+	"net/url"
+	"sync"
+	"unicode/utf8"
 )
 
 const (
 	attestationTagSize             = 8
-	attestationMaxPayloadSize      = 8192
+	attestationMaxPayloadSize      = 8192 // inclusive
 	pendingAttestationMaxUriLength = 1000
 )
 
+// PendingAttestationURISchemes lists the URI schemes accepted for pending
+// attestations. Embedders running a calendar behind a custom protocol can
+// extend it.
+var PendingAttestationURISchemes = []string{"http", "https"}
+
 var (
-	bitcoinAttestationTag = mustDecodeHex("0588960d73d71901")
-	pendingAttestationTag = mustDecodeHex("83dfe30d2ef90c8e")
+	bitcoinAttestationTag  = mustDecodeHex("0588960d73d71901")
+	litecoinAttestationTag = mustDecodeHex("06869a0d73d71b45")
+	ethereumAttestationTag = mustDecodeHex("30fe8087b5c7ead7")
+	pendingAttestationTag  = mustDecodeHex("83dfe30d2ef90c8e")
 )
 
+// An Attestation is a statement that a message existed at a certain time,
+// e.g. because it is committed to in a bitcoin block. Callers can type switch
+// over the concrete types *PendingAttestation, *BitcoinAttestation,
+// *LitecoinAttestation, *EthereumAttestation and *UnknownAttestation. Other
+// types can be added with RegisterAttestation.
 type Attestation interface {
-	tag() []byte
-	decode(*deserializationContext) (Attestation, error)
-	encode(*serializationContext) error
+	fmt.Stringer
+	// Tag returns a copy of the 8-byte tag identifying the attestation type.
+	Tag() []byte
+	// Decode returns a new attestation of the same type read from the
+	// payload in ctx. It is called on the registered prototype, which must
+	// not be modified. Bytes left in the payload are ErrTrailingBytes when
+	// parsing strictly, otherwise the payload is kept unparsed as an
+	// UnknownAttestation.
+	Decode(ctx *DeserializationContext) (Attestation, error)
+	// Encode writes the payload, without the tag and length prefix.
+	Encode(ctx *SerializationContext) error
+	// Equal returns true if other has the same tag and payload.
+	Equal(other Attestation) bool
+}
+
+// attestationTag returns the tag of a, without a copy for the built-in
+// types.
+func attestationTag(a Attestation) []byte {
+	if t, ok := a.(interface{ tag() []byte }); ok {
+		return t.tag()
+	}
+	return a.Tag()
 }
 
 type baseAttestation struct {
@@ -30,43 +64,93 @@ func (b *baseAttestation) tag() []byte {
 	return b.fixedTag
 }
 
-type pendingAttestation struct {
+func (b *baseAttestation) Tag() []byte {
+	return copyBytes(b.fixedTag)
+}
+
+type PendingAttestation struct {
 	baseAttestation
 	uri string
 }
 
-func newPendingAttestation() *pendingAttestation {
-	return &pendingAttestation{
+func newPendingAttestation() *PendingAttestation {
+	return &PendingAttestation{
 		baseAttestation: baseAttestation{
 			fixedTag: pendingAttestationTag,
 		},
 	}
 }
 
-func (p *pendingAttestation) decode(
-	ctx *deserializationContext,
+// validatePendingURI makes sure the calendar uri can be fetched safely. It is
+// checked when parsing strictly and again before a calendar is contacted.
+func validatePendingURI(uri string) error {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return fmt.Errorf("invalid pending attestation uri: %v", err)
+	}
+	for _, scheme := range PendingAttestationURISchemes {
+		if u.Scheme == scheme {
+			return nil
+		}
+	}
+	return fmt.Errorf(
+		"pending attestation uri %q: scheme %q not allowed",
+		uri, u.Scheme,
+	)
+}
+
+// NewPendingAttestation returns a pending attestation for the calendar at uri.
+func NewPendingAttestation(uri string) *PendingAttestation {
+	p := newPendingAttestation()
+	p.uri = uri
+	return p
+}
+
+// URI returns the calendar that can be queried to upgrade the attestation.
+func (p *PendingAttestation) URI() string {
+	return p.uri
+}
+
+func (p *PendingAttestation) Decode(
+	ctx *DeserializationContext,
 ) (Attestation, error) {
-	uri, err := ctx.readVarBytes(0, pendingAttestationMaxUriLength)
+	uri, err := ctx.ReadVarBytes(0, ctx.opts.MaxURILength)
 	if err != nil {
 		return nil, err
 	}
-	// TODO utf8 checks
+	if ctx.opts.Strict {
+		if !utf8.Valid(uri) {
+			return nil, fmt.Errorf(
+				"invalid utf8 in pending attestation uri %q", uri,
+			)
+		}
+		if err := validatePendingURI(string(uri)); err != nil {
+			return nil, err
+		}
+	}
 	ret := *p
 	ret.uri = string(uri)
 	return &ret, nil
 }
 
-func (p *pendingAttestation) encode(ctx *serializationContext) error {
-	return ctx.writeVarBytes([]byte(p.uri))
+func (p *PendingAttestation) Encode(ctx *SerializationContext) error {
+	return ctx.WriteVarBytes([]byte(p.uri))
 }
 
-func (p *pendingAttestation) String() string {
+func (p *PendingAttestation) Equal(other Attestation) bool {
+	if o, ok := other.(*PendingAttestation); ok {
+		return p.uri == o.uri
+	}
+	return sameEncoding(p, other)
+}
+
+func (p *PendingAttestation) String() string {
 	return fmt.Sprintf("VERIFY PendingAttestation(url=%s)", p.uri)
 }
 
 type BitcoinAttestation struct {
 	baseAttestation
-	Height uint64
+	height uint64
 }
 
 func newBitcoinAttestation() *BitcoinAttestation {
@@ -75,29 +159,52 @@ func newBitcoinAttestation() *BitcoinAttestation {
 	}
 }
 
+// NewBitcoinAttestation returns an attestation for the bitcoin block at height.
+func NewBitcoinAttestation(height uint64) *BitcoinAttestation {
+	b := newBitcoinAttestation()
+	b.height = height
+	return b
+}
+
+// Height returns the bitcoin block height the attestation commits to.
+func (b *BitcoinAttestation) Height() uint64 {
+	return b.height
+}
+
+func (b *BitcoinAttestation) Equal(other Attestation) bool {
+	if o, ok := other.(*BitcoinAttestation); ok {
+		return b.height == o.height
+	}
+	return sameEncoding(b, other)
+}
+
 func (b *BitcoinAttestation) String() string {
-	return fmt.Sprintf("VERIFY BitcoinAttestation(height=%d)", b.Height)
+	return fmt.Sprintf("VERIFY BitcoinAttestation(height=%d)", b.height)
 }
 
-func (b *BitcoinAttestation) decode(
-	ctx *deserializationContext,
+func (b *BitcoinAttestation) Decode(
+	ctx *DeserializationContext,
 ) (Attestation, error) {
-	height, err := ctx.readVarUint()
+	height, err := ctx.ReadVarUint()
 	if err != nil {
 		return nil, err
 	}
+	if max := ctx.opts.MaxBitcoinHeight; height > max {
+		return nil, fmt.Errorf(
+			"%w: %d exceeds %d", ErrImplausibleHeight, height, max,
+		)
+	}
 	ret := *b
-	ret.Height = height
+	ret.height = height
 	return &ret, nil
 }
 
-func (b *BitcoinAttestation) encode(ctx *serializationContext) error {
-	return ctx.writeVarUint(uint64(b.Height))
+func (b *BitcoinAttestation) Encode(ctx *SerializationContext) error {
+	return ctx.WriteVarUint(uint64(b.height))
 }
 
 const hashMerkleRootSize = 32
 
-//
 func (b *BitcoinAttestation) VerifyAgainstBlockHash(
 	digest, blockHash []byte,
 ) error {
@@ -106,78 +213,349 @@ func (b *BitcoinAttestation) VerifyAgainstBlockHash(
 	}
 	if !bytes.Equal(digest, blockHash) {
 		return fmt.Errorf(
-			"hash mismatch digest=%x blockHash=%x",
-			digest, blockHash,
+			"%w: digest=%x blockHash=%x",
+			ErrMerkleRootMismatch, digest, blockHash,
 		)
 	}
 	return nil
 }
 
+type LitecoinAttestation struct {
+	baseAttestation
+	height uint64
+}
+
+func newLitecoinAttestation() *LitecoinAttestation {
+	return &LitecoinAttestation{
+		baseAttestation: baseAttestation{litecoinAttestationTag},
+	}
+}
+
+// NewLitecoinAttestation returns an attestation for the litecoin block at height.
+func NewLitecoinAttestation(height uint64) *LitecoinAttestation {
+	l := newLitecoinAttestation()
+	l.height = height
+	return l
+}
+
+// Height returns the litecoin block height the attestation commits to.
+func (l *LitecoinAttestation) Height() uint64 {
+	return l.height
+}
+
+func (l *LitecoinAttestation) Equal(other Attestation) bool {
+	if o, ok := other.(*LitecoinAttestation); ok {
+		return l.height == o.height
+	}
+	return sameEncoding(l, other)
+}
+
+func (l *LitecoinAttestation) String() string {
+	return fmt.Sprintf("VERIFY LitecoinAttestation(height=%d)", l.height)
+}
+
+func (l *LitecoinAttestation) Decode(
+	ctx *DeserializationContext,
+) (Attestation, error) {
+	height, err := ctx.ReadVarUint()
+	if err != nil {
+		return nil, err
+	}
+	ret := *l
+	ret.height = height
+	return &ret, nil
+}
+
+func (l *LitecoinAttestation) Encode(ctx *SerializationContext) error {
+	return ctx.WriteVarUint(l.height)
+}
+
+type EthereumAttestation struct {
+	baseAttestation
+	height uint64
+}
+
+func newEthereumAttestation() *EthereumAttestation {
+	return &EthereumAttestation{
+		baseAttestation: baseAttestation{ethereumAttestationTag},
+	}
+}
+
+// NewEthereumAttestation returns an attestation for the ethereum block at height.
+func NewEthereumAttestation(height uint64) *EthereumAttestation {
+	e := newEthereumAttestation()
+	e.height = height
+	return e
+}
+
+// Height returns the ethereum block height the attestation commits to.
+func (e *EthereumAttestation) Height() uint64 {
+	return e.height
+}
+
+func (e *EthereumAttestation) Equal(other Attestation) bool {
+	if o, ok := other.(*EthereumAttestation); ok {
+		return e.height == o.height
+	}
+	return sameEncoding(e, other)
+}
+
+func (e *EthereumAttestation) String() string {
+	return fmt.Sprintf("VERIFY EthereumAttestation(height=%d)", e.height)
+}
+
+func (e *EthereumAttestation) Decode(
+	ctx *DeserializationContext,
+) (Attestation, error) {
+	height, err := ctx.ReadVarUint()
+	if err != nil {
+		return nil, err
+	}
+	ret := *e
+	ret.height = height
+	return &ret, nil
+}
+
+func (e *EthereumAttestation) Encode(ctx *SerializationContext) error {
+	return ctx.WriteVarUint(e.height)
+}
+
 // This is a catch-all for when we don't know how to parse it
-type unknownAttestation struct {
+type UnknownAttestation struct {
 	tagBytes []byte
 	bytes    []byte
 }
 
-func (u unknownAttestation) tag() []byte {
+func (u *UnknownAttestation) tag() []byte {
 	return u.tagBytes
 }
 
-func (unknownAttestation) decode(*deserializationContext) (Attestation, error) {
-	panic("not implemented")
+func (u *UnknownAttestation) Tag() []byte {
+	return copyBytes(u.tagBytes)
 }
 
-func (unknownAttestation) encode(*serializationContext) error {
-	panic("not implemented")
+// Decode captures the remaining payload verbatim so that Encode can re-emit
+// it byte-for-byte.
+func (u *UnknownAttestation) Decode(
+	ctx *DeserializationContext,
+) (Attestation, error) {
+	payload, err := ctx.readRemaining()
+	if err != nil {
+		return nil, err
+	}
+	return &UnknownAttestation{u.tagBytes, payload}, nil
+}
+
+func (u *UnknownAttestation) Encode(ctx *SerializationContext) error {
+	return ctx.WriteBytes(u.bytes)
+}
+
+// Equal also returns true for a recognized attestation that has the same tag
+// and payload.
+func (u *UnknownAttestation) Equal(other Attestation) bool {
+	return sameEncoding(u, other)
 }
 
-func (u unknownAttestation) String() string {
-	return fmt.Sprintf("UnknownAttestation(bytes=%q)", u.bytes)
+// Payload returns a copy of the raw attestation payload.
+func (u *UnknownAttestation) Payload() []byte {
+	return copyBytes(u.bytes)
 }
 
-var attestations []Attestation = []Attestation{
-	newPendingAttestation(),
-	newBitcoinAttestation(),
+// maximum number of payload bytes shown by UnknownAttestation.String()
+const unknownAttestationMaxDisplayBytes = 64
+
+func (u *UnknownAttestation) String() string {
+	if len(u.bytes) > unknownAttestationMaxDisplayBytes {
+		return fmt.Sprintf(
+			"UnknownAttestation(tag=%x, bytes=%q... (%d bytes))",
+			u.tagBytes,
+			u.bytes[:unknownAttestationMaxDisplayBytes],
+			len(u.bytes),
+		)
+	}
+	return fmt.Sprintf(
+		"UnknownAttestation(tag=%x, bytes=%q)", u.tagBytes, u.bytes,
+	)
 }
 
-func encodeAttestation(ctx *serializationContext, att Attestation) error {
-	if err := ctx.writeBytes(att.tag()); err != nil {
+var (
+	// attestationsMu guards attestations, which can be extended via
+	// RegisterAttestation while other goroutines are parsing.
+	attestationsMu sync.RWMutex
+	attestations   []Attestation = []Attestation{
+		newPendingAttestation(),
+		newBitcoinAttestation(),
+		newLitecoinAttestation(),
+		newEthereumAttestation(),
+	}
+)
+
+func init() {
+	for i, a := range attestations {
+		if err := checkUniqueTag(attestations[:i], a); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// checkUniqueTag returns an error if one of the protos has the tag of proto.
+func checkUniqueTag(protos []Attestation, proto Attestation) error {
+	for _, a := range protos {
+		if bytes.Equal(attestationTag(a), attestationTag(proto)) {
+			return fmt.Errorf(
+				"%w: %x of %T already registered by %T",
+				ErrDuplicateAttestationTag, attestationTag(proto), proto, a,
+			)
+		}
+	}
+	return nil
+}
+
+// RegisterAttestation adds a prototype to the attestations recognized by
+// ParseAttestation. Its Decode method is called for every attestation with a
+// matching tag. Prototypes are matched in registration order and the first
+// match wins, after the built-in types. Registering a tag that is already
+// known returns ErrDuplicateAttestationTag.
+func RegisterAttestation(proto Attestation) error {
+	if n := len(attestationTag(proto)); n != attestationTagSize {
+		return fmt.Errorf(
+			"attestation tag must be %d bytes, got %d",
+			attestationTagSize, n,
+		)
+	}
+	attestationsMu.Lock()
+	defer attestationsMu.Unlock()
+	if err := checkUniqueTag(attestations, proto); err != nil {
 		return err
 	}
+	attestations = append(attestations, proto)
+	return nil
+}
+
+// findAttestation returns the registered prototype for tag, or nil.
+func findAttestation(tag []byte) Attestation {
+	attestationsMu.RLock()
+	defer attestationsMu.RUnlock()
+	for _, a := range attestations {
+		if bytes.Equal(tag, attestationTag(a)) {
+			return a
+		}
+	}
+	return nil
+}
+
+// encodePayload returns the encoded attestation without tag and length
+// prefix.
+func encodePayload(att Attestation) ([]byte, error) {
 	buf := &bytes.Buffer{}
-	if err := att.encode(&serializationContext{buf}); err != nil {
+	if err := att.Encode(newSerializationContext(buf)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// sameEncoding returns true if both attestations have the same tag and
+// encode to the same payload.
+func sameEncoding(a, b Attestation) bool {
+	if a == nil || b == nil ||
+		!bytes.Equal(attestationTag(a), attestationTag(b)) {
+		return false
+	}
+	payloadA, err := encodePayload(a)
+	if err != nil {
+		return false
+	}
+	payloadB, err := encodePayload(b)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(payloadA, payloadB)
+}
+
+func encodeAttestation(ctx *SerializationContext, att Attestation) error {
+	if err := ctx.WriteBytes(attestationTag(att)); err != nil {
 		return err
 	}
-	return ctx.writeVarBytes(buf.Bytes())
+	payload, err := encodePayload(att)
+	if err != nil {
+		return err
+	}
+	return ctx.WriteVarBytes(payload)
+}
+
+// ParseAttestation reads an attestation using the options of ctx.
+func ParseAttestation(ctx *DeserializationContext) (Attestation, error) {
+	return ParseAttestationWithOptions(ctx, ctx.opts)
 }
 
-func ParseAttestation(ctx *deserializationContext) (Attestation, error) {
-	tag, err := ctx.readBytes(attestationTagSize)
+// ParseAttestationWithOptions reads an attestation, enforcing the payload and
+// uri size limits in opts.
+func ParseAttestationWithOptions(
+	ctx *DeserializationContext, opts ParseOptions,
+) (Attestation, error) {
+	opts = opts.withDefaults()
+	tag, err := ctx.ReadBytes(attestationTagSize)
 	if err != nil {
 		return nil, err
 	}
 
-	attBytes, err := ctx.readVarBytes(
-		0, attestationMaxPayloadSize,
-	)
+	attBytes, err := ctx.ReadVarBytes(0, opts.MaxPayloadSize)
 	if err != nil {
 		return nil, err
 	}
-	attCtx := newDeserializationContext(
-		bytes.NewBuffer(attBytes),
-	)
+	// attBytes is not used elsewhere, the decoded attestation may keep
+	// parts of it without a copy
+	attCtx := getDeserializationContext(nil, opts)
+	defer putDeserializationContext(attCtx)
+	attCtx.resetBytes(attBytes, opts)
+	// keep offsets in errors relative to the outer stream
+	attCtx.offset = ctx.offset - int64(len(attBytes))
 
-	for _, a := range attestations {
-		if bytes.Equal(tag, a.tag()) {
-			att, err := a.decode(attCtx)
-			if err != nil {
-				return nil, err
-			}
-			if !attCtx.assertEOF() {
-				return nil, fmt.Errorf("expected EOF in attCtx")
-			}
-			return att, nil
+	proto := findAttestation(tag)
+	if proto == nil {
+		proto = &UnknownAttestation{tagBytes: tag}
+	}
+	name, field := describeAttestation(proto)
+	att, err := proto.Decode(attCtx)
+	if err != nil {
+		err = fmt.Errorf("%s: %w", name, err)
+	} else if trailing := ctx.offset - attCtx.offset; trailing > 0 {
+		if !opts.Strict {
+			// kept as it is, so the extra bytes survive a round trip
+			return &UnknownAttestation{
+				tagBytes: copyBytes(tag), bytes: copyBytes(attBytes),
+			}, nil
 		}
+		// attCtx ends where ctx currently is
+		err = fmt.Errorf(
+			"%s: %d %w after %s", name, trailing, ErrTrailingBytes, field,
+		)
+	}
+	if err != nil {
+		if !ctx.recover(err) {
+			return nil, err
+		}
+		// the payload was read completely, keep it as it is
+		return &UnknownAttestation{
+			tagBytes: copyBytes(tag), bytes: copyBytes(attBytes),
+		}, nil
+	}
+	return att, nil
+}
+
+// describeAttestation returns the name of the attestation type and its last
+// field for error messages.
+func describeAttestation(a Attestation) (name, field string) {
+	switch a.(type) {
+	case *PendingAttestation:
+		return "pending attestation", "uri"
+	case *BitcoinAttestation:
+		return "bitcoin attestation", "height"
+	case *LitecoinAttestation:
+		return "litecoin attestation", "height"
+	case *EthereumAttestation:
+		return "ethereum attestation", "height"
+	default:
+		return fmt.Sprintf("%T", a), "payload"
 	}
-	return unknownAttestation{tag, attBytes}, nil
 }
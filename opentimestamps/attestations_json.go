@@ -0,0 +1,179 @@
+package opentimestamps
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	jsonTypePending  = "pending"
+	jsonTypeBitcoin  = "bitcoin"
+	jsonTypeLitecoin = "litecoin"
+	jsonTypeEthereum = "ethereum"
+	jsonTypeUnknown  = "unknown"
+)
+
+// attestationJSON is the typed JSON representation shared by all
+// attestations. Byte values are hex encoded.
+type attestationJSON struct {
+	Type    string  `json:"type"`
+	Height  *uint64 `json:"height,omitempty"`
+	URI     string  `json:"uri,omitempty"`
+	Tag     string  `json:"tag,omitempty"`
+	Payload string  `json:"payload,omitempty"`
+}
+
+func unmarshalAttestationJSON(
+	data []byte, expectedType string,
+) (*attestationJSON, error) {
+	v := &attestationJSON{}
+	if err := json.Unmarshal(data, v); err != nil {
+		return nil, err
+	}
+	if v.Type != expectedType {
+		return nil, fmt.Errorf(
+			"expected attestation type %q, got %q", expectedType, v.Type,
+		)
+	}
+	return v, nil
+}
+
+func unmarshalHeightJSON(data []byte, expectedType string) (uint64, error) {
+	v, err := unmarshalAttestationJSON(data, expectedType)
+	if err != nil {
+		return 0, err
+	}
+	if v.Height == nil {
+		return 0, fmt.Errorf("%s attestation without height", expectedType)
+	}
+	return *v.Height, nil
+}
+
+func (p *PendingAttestation) MarshalJSON() ([]byte, error) {
+	return json.Marshal(attestationJSON{
+		Type: jsonTypePending,
+		URI:  p.uri,
+	})
+}
+
+func (p *PendingAttestation) UnmarshalJSON(data []byte) error {
+	v, err := unmarshalAttestationJSON(data, jsonTypePending)
+	if err != nil {
+		return err
+	}
+	if err := validatePendingURI(v.URI); err != nil {
+		return err
+	}
+	*p = *NewPendingAttestation(v.URI)
+	return nil
+}
+
+func (b *BitcoinAttestation) MarshalJSON() ([]byte, error) {
+	return json.Marshal(attestationJSON{
+		Type:   jsonTypeBitcoin,
+		Height: &b.height,
+	})
+}
+
+func (b *BitcoinAttestation) UnmarshalJSON(data []byte) error {
+	height, err := unmarshalHeightJSON(data, jsonTypeBitcoin)
+	if err != nil {
+		return err
+	}
+	*b = *NewBitcoinAttestation(height)
+	return nil
+}
+
+func (l *LitecoinAttestation) MarshalJSON() ([]byte, error) {
+	return json.Marshal(attestationJSON{
+		Type:   jsonTypeLitecoin,
+		Height: &l.height,
+	})
+}
+
+func (l *LitecoinAttestation) UnmarshalJSON(data []byte) error {
+	height, err := unmarshalHeightJSON(data, jsonTypeLitecoin)
+	if err != nil {
+		return err
+	}
+	*l = *NewLitecoinAttestation(height)
+	return nil
+}
+
+func (e *EthereumAttestation) MarshalJSON() ([]byte, error) {
+	return json.Marshal(attestationJSON{
+		Type:   jsonTypeEthereum,
+		Height: &e.height,
+	})
+}
+
+func (e *EthereumAttestation) UnmarshalJSON(data []byte) error {
+	height, err := unmarshalHeightJSON(data, jsonTypeEthereum)
+	if err != nil {
+		return err
+	}
+	*e = *NewEthereumAttestation(height)
+	return nil
+}
+
+func (u *UnknownAttestation) MarshalJSON() ([]byte, error) {
+	return json.Marshal(attestationJSON{
+		Type:    jsonTypeUnknown,
+		Tag:     hex.EncodeToString(u.tagBytes),
+		Payload: hex.EncodeToString(u.bytes),
+	})
+}
+
+func (u *UnknownAttestation) UnmarshalJSON(data []byte) error {
+	v, err := unmarshalAttestationJSON(data, jsonTypeUnknown)
+	if err != nil {
+		return err
+	}
+	tag, err := hex.DecodeString(v.Tag)
+	if err != nil {
+		return fmt.Errorf("invalid unknown attestation tag: %v", err)
+	}
+	if len(tag) != attestationTagSize {
+		return fmt.Errorf(
+			"expected %d byte tag, got %d", attestationTagSize, len(tag),
+		)
+	}
+	payload, err := hex.DecodeString(v.Payload)
+	if err != nil {
+		return fmt.Errorf("invalid unknown attestation payload: %v", err)
+	}
+	*u = UnknownAttestation{tag, payload}
+	return nil
+}
+
+// UnmarshalAttestationJSON decodes the JSON representation produced by
+// json.Marshal for any of the attestation types.
+func UnmarshalAttestationJSON(data []byte) (Attestation, error) {
+	v := &attestationJSON{}
+	if err := json.Unmarshal(data, v); err != nil {
+		return nil, err
+	}
+	var att interface {
+		Attestation
+		json.Unmarshaler
+	}
+	switch v.Type {
+	case jsonTypePending:
+		att = &PendingAttestation{}
+	case jsonTypeBitcoin:
+		att = &BitcoinAttestation{}
+	case jsonTypeLitecoin:
+		att = &LitecoinAttestation{}
+	case jsonTypeEthereum:
+		att = &EthereumAttestation{}
+	case jsonTypeUnknown:
+		att = &UnknownAttestation{}
+	default:
+		return nil, fmt.Errorf("unknown attestation type %q", v.Type)
+	}
+	if err := att.UnmarshalJSON(data); err != nil {
+		return nil, err
+	}
+	return att, nil
+}
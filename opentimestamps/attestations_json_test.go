@@ -0,0 +1,70 @@
+package opentimestamps
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAttestationJSON(t *testing.T) {
+	for _, c := range []struct {
+		att      Attestation
+		expected string
+	}{
+		{
+			NewBitcoinAttestation(812345),
+			`{"type":"bitcoin","height":812345}`,
+		},
+		{
+			NewBitcoinAttestation(0),
+			`{"type":"bitcoin","height":0}`,
+		},
+		{
+			NewLitecoinAttestation(1),
+			`{"type":"litecoin","height":1}`,
+		},
+		{
+			NewEthereumAttestation(2),
+			`{"type":"ethereum","height":2}`,
+		},
+		{
+			NewPendingAttestation("https://a.pool.opentimestamps.org"),
+			`{"type":"pending","uri":"https://a.pool.opentimestamps.org"}`,
+		},
+		{
+			&UnknownAttestation{
+				mustDecodeHex("0102030405060708"), []byte{0x00, 0xff},
+			},
+			`{"type":"unknown","tag":"0102030405060708","payload":"00ff"}`,
+		},
+	} {
+		data, err := json.Marshal(c.att)
+		require.NoError(t, err)
+		assert.Equal(t, c.expected, string(data))
+
+		att, err := UnmarshalAttestationJSON(data)
+		require.NoError(t, err)
+		assert.Equal(t, c.att, att)
+	}
+}
+
+func TestAttestationJSONErrors(t *testing.T) {
+	for _, data := range []string{
+		`{"type":"bitcoin"}`,
+		`{"type":"pending","uri":"ftp://example.com"}`,
+		`{"type":"unknown","tag":"01","payload":""}`,
+		`{"type":"unknown","tag":"0102030405060708","payload":"zz"}`,
+		`{"type":"carrier-pigeon"}`,
+	} {
+		_, err := UnmarshalAttestationJSON([]byte(data))
+		assert.Error(t, err, data)
+	}
+
+	// type mismatch when unmarshalling into a concrete type
+	b := &BitcoinAttestation{}
+	assert.Error(t, json.Unmarshal(
+		[]byte(`{"type":"litecoin","height":1}`), b,
+	))
+}
@@ -0,0 +1,621 @@
+package opentimestamps
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func encodeAttestationToBytes(t *testing.T, att Attestation) []byte {
+	buf := &bytes.Buffer{}
+	require.NoError(t, encodeAttestation(newSerializationContext(buf), att))
+	return buf.Bytes()
+}
+
+func TestAttestationRoundTrip(t *testing.T) {
+	for _, att := range []Attestation{
+		NewPendingAttestation("https://alice.btc.calendar.opentimestamps.org"),
+		NewPendingAttestation("http://localhost:14788"),
+		NewBitcoinAttestation(0),
+		NewBitcoinAttestation(358391),
+		NewLitecoinAttestation(1234567),
+		NewEthereumAttestation(5000000),
+		&UnknownAttestation{
+			tagBytes: mustDecodeHex("0102030405060708"),
+			bytes:    []byte("payload"),
+		},
+	} {
+		encoded := encodeAttestationToBytes(t, att)
+
+		decoded, err := ParseAttestation(
+			newDeserializationContextFromBytes(encoded),
+		)
+		require.NoError(t, err)
+		assert.Equal(t, att, decoded)
+
+		// encoding the decoded attestation must be byte-stable
+		assert.Equal(t, encoded, encodeAttestationToBytes(t, decoded))
+	}
+}
+
+func TestEncodeBitcoinAttestation(t *testing.T) {
+	assert.Equal(t,
+		append(
+			mustDecodeHex("0588960d73d71901"),
+			// varbytes length 3, varuint 358391
+			0x03, 0xf7, 0xef, 0x15,
+		),
+		encodeAttestationToBytes(t, NewBitcoinAttestation(358391)),
+	)
+}
+
+func TestUnknownAttestationFixture(t *testing.T) {
+	dts, err := NewDetachedTimestampFromPath(
+		"../examples/unknown-notary.txt.ots",
+	)
+	require.NoError(t, err)
+
+	var unknown []*UnknownAttestation
+	dts.Timestamp.Walk(func(ts *Timestamp) {
+		for _, att := range ts.Attestations {
+			if u, ok := att.(*UnknownAttestation); ok {
+				unknown = append(unknown, u)
+			}
+		}
+	})
+	require.Equal(t, 1, len(unknown))
+	assert.Equal(t, mustDecodeHex("0102030405060708"), unknown[0].tag())
+	assert.Equal(t,
+		bytes.Repeat([]byte("x"), 46), unknown[0].bytes,
+	)
+
+	decoded, err := unknown[0].Decode(
+		newDeserializationContextFromBytes(unknown[0].bytes),
+	)
+	require.NoError(t, err)
+	assert.Equal(t, unknown[0], decoded)
+}
+
+func TestParseLitecoinAttestation(t *testing.T) {
+	att, err := ParseAttestation(newDeserializationContextFromBytes(
+		append(mustDecodeHex("06869a0d73d71b45"), 0x02, 0xac, 0x02),
+	))
+	require.NoError(t, err)
+	assert.Equal(t, NewLitecoinAttestation(300), att)
+	assert.Equal(t,
+		"VERIFY LitecoinAttestation(height=300)",
+		att.String(),
+	)
+
+	// trailing bytes after the height are rejected when strict, otherwise
+	// the attestation is kept unparsed
+	trailing := append(
+		mustDecodeHex("06869a0d73d71b45"), 0x03, 0xac, 0x02, 0x00,
+	)
+	_, err = parseAttestationStrict(trailing)
+	assert.Error(t, err)
+	att, err = ParseAttestation(newDeserializationContextFromBytes(trailing))
+	require.NoError(t, err)
+	assert.IsType(t, &UnknownAttestation{}, att)
+}
+
+func TestParseEthereumAttestation(t *testing.T) {
+	// same payload as a bitcoin attestation, only the tag differs
+	payload := []byte{0x02, 0xac, 0x02}
+	att, err := ParseAttestation(newDeserializationContextFromBytes(
+		append(mustDecodeHex("30fe8087b5c7ead7"), payload...),
+	))
+	require.NoError(t, err)
+	assert.Equal(t, NewEthereumAttestation(300), att)
+	assert.Equal(t,
+		"VERIFY EthereumAttestation(height=300)",
+		att.String(),
+	)
+
+	att, err = ParseAttestation(newDeserializationContextFromBytes(
+		append(mustDecodeHex("0588960d73d71901"), payload...),
+	))
+	require.NoError(t, err)
+	assert.Equal(t, NewBitcoinAttestation(300), att)
+
+	trailing := append(
+		mustDecodeHex("30fe8087b5c7ead7"), 0x03, 0xac, 0x02, 0xff,
+	)
+	_, err = parseAttestationStrict(trailing)
+	assert.Error(t, err)
+	att, err = ParseAttestation(newDeserializationContextFromBytes(trailing))
+	require.NoError(t, err)
+	assert.IsType(t, &UnknownAttestation{}, att)
+}
+
+// parseAttestationStrict parses a serialized attestation with Strict set.
+func parseAttestationStrict(data []byte) (Attestation, error) {
+	return ParseAttestationWithOptions(
+		newDeserializationContextFromBytes(data), ParseOptions{Strict: true},
+	)
+}
+
+func parsePendingAttestationURI(
+	uri []byte, opts ParseOptions,
+) (Attestation, error) {
+	buf := &bytes.Buffer{}
+	ctx := newSerializationContext(buf)
+	if err := ctx.WriteBytes(pendingAttestationTag); err != nil {
+		panic(err)
+	}
+	payload := &bytes.Buffer{}
+	if err := newSerializationContext(payload).WriteVarBytes(uri); err != nil {
+		panic(err)
+	}
+	if err := ctx.WriteVarBytes(payload.Bytes()); err != nil {
+		panic(err)
+	}
+	return ParseAttestationWithOptions(
+		newDeserializationContextFromBytes(buf.Bytes()), opts,
+	)
+}
+
+func TestPendingAttestationUTF8(t *testing.T) {
+	strict := ParseOptions{Strict: true}
+	invalid := []byte("https://\xff\xfe")
+	_, err := parsePendingAttestationURI(invalid, strict)
+	assert.Error(t, err)
+	att, err := parsePendingAttestationURI(invalid, ParseOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, string(invalid), att.(*PendingAttestation).URI())
+
+	// truncated multi-byte sequence right at the length limit
+	maxLen := pendingAttestationMaxUriLength
+	truncated := append(bytes.Repeat([]byte("a"), maxLen-1), 0xe2)
+	_, err = parsePendingAttestationURI(truncated, strict)
+	assert.Error(t, err)
+
+	complete := append([]byte("https://"), bytes.Repeat(
+		[]byte("a"), maxLen-len("https://")-3,
+	)...)
+	complete = append(complete, "\u2713"...)
+	att, err = parsePendingAttestationURI(complete, strict)
+	require.NoError(t, err)
+	assert.Equal(t, string(complete), att.(*PendingAttestation).URI())
+}
+
+func TestPendingAttestationURIScheme(t *testing.T) {
+	strict := ParseOptions{Strict: true}
+	att, err := parsePendingAttestationURI(
+		[]byte("https://alice.btc.calendar.opentimestamps.org"), strict,
+	)
+	require.NoError(t, err)
+	assert.Equal(t,
+		"https://alice.btc.calendar.opentimestamps.org",
+		att.(*PendingAttestation).URI(),
+	)
+
+	for _, uri := range []string{
+		"ftp://alice.btc.calendar.opentimestamps.org",
+		"file:///etc/passwd",
+		"javascript:alert(1)",
+		"alice.btc.calendar.opentimestamps.org",
+		"",
+	} {
+		_, err := parsePendingAttestationURI([]byte(uri), strict)
+		assert.Error(t, err, uri)
+
+		// accepted when lenient, but never contacted as a calendar
+		att, err := parsePendingAttestationURI([]byte(uri), ParseOptions{})
+		require.NoError(t, err, uri)
+		assert.Equal(t, uri, att.(*PendingAttestation).URI())
+		_, err = NewRemoteCalendar(uri)
+		assert.Error(t, err, uri)
+	}
+}
+
+func TestAttestationEqual(t *testing.T) {
+	alice := "https://alice.btc.calendar.opentimestamps.org"
+	bob := "https://bob.btc.calendar.opentimestamps.org"
+
+	assert.True(t, NewPendingAttestation(alice).Equal(
+		NewPendingAttestation(alice),
+	))
+	assert.False(t, NewPendingAttestation(alice).Equal(
+		NewPendingAttestation(bob),
+	))
+	assert.True(t, NewBitcoinAttestation(1).Equal(
+		NewBitcoinAttestation(1),
+	))
+	assert.False(t, NewBitcoinAttestation(1).Equal(
+		NewBitcoinAttestation(2),
+	))
+	// same height, different chain
+	assert.False(t, NewBitcoinAttestation(1).Equal(
+		NewLitecoinAttestation(1),
+	))
+	assert.False(t, NewLitecoinAttestation(1).Equal(
+		NewEthereumAttestation(1),
+	))
+	assert.False(t, NewBitcoinAttestation(1).Equal(nil))
+
+	u := &UnknownAttestation{mustDecodeHex("0102030405060708"), []byte("x")}
+	assert.True(t, u.Equal(
+		&UnknownAttestation{mustDecodeHex("0102030405060708"), []byte("x")},
+	))
+	assert.False(t, u.Equal(
+		&UnknownAttestation{mustDecodeHex("0102030405060708"), []byte("y")},
+	))
+	assert.False(t, u.Equal(
+		&UnknownAttestation{mustDecodeHex("0807060504030201"), []byte("x")},
+	))
+
+	// an unknown attestation carrying a recognized tag and payload
+	unknownBTC := &UnknownAttestation{bitcoinAttestationTag, []byte{0x01}}
+	assert.True(t, unknownBTC.Equal(NewBitcoinAttestation(1)))
+	assert.True(t, NewBitcoinAttestation(1).Equal(unknownBTC))
+
+	// the payload and tag are copies
+	unknownBTC.Payload()[0] = 0x02
+	unknownBTC.Tag()[0] = 0x00
+	assert.True(t, unknownBTC.Equal(NewBitcoinAttestation(1)))
+}
+
+func TestAttestationTypeSwitch(t *testing.T) {
+	dts, err := NewDetachedTimestampFromPath(
+		"../examples/known-and-unknown-notary.txt.ots",
+	)
+	require.NoError(t, err)
+
+	var pending, unknown int
+	dts.Timestamp.Walk(func(ts *Timestamp) {
+		for _, att := range ts.Attestations {
+			switch a := att.(type) {
+			case *PendingAttestation:
+				assert.Equal(t,
+					"https://bob.btc.calendar.opentimestamps.org",
+					a.URI(),
+				)
+				pending++
+			case *UnknownAttestation:
+				assert.Equal(t, 46, len(a.Payload()))
+				unknown++
+			default:
+				t.Errorf("unexpected attestation %v", a)
+			}
+		}
+	})
+	assert.Equal(t, 1, pending)
+	assert.Equal(t, 1, unknown)
+}
+
+func TestAttestationTag(t *testing.T) {
+	for _, c := range []struct {
+		att Attestation
+		tag string
+	}{
+		{NewPendingAttestation("https://example.com"), "83dfe30d2ef90c8e"},
+		{NewBitcoinAttestation(1), "0588960d73d71901"},
+		{NewLitecoinAttestation(1), "06869a0d73d71b45"},
+		{NewEthereumAttestation(1), "30fe8087b5c7ead7"},
+		{&UnknownAttestation{mustDecodeHex("0102030405060708"), nil},
+			"0102030405060708"},
+	} {
+		tag := c.att.Tag()
+		assert.Equal(t, mustDecodeHex(c.tag), tag)
+
+		// modifying the returned tag must not affect the attestation
+		tag[0] ^= 0xff
+		assert.Equal(t, mustDecodeHex(c.tag), c.att.Tag())
+	}
+}
+
+func encodeRawAttestation(tag, payload []byte) []byte {
+	buf := &bytes.Buffer{}
+	ctx := newSerializationContext(buf)
+	if err := ctx.WriteBytes(tag); err != nil {
+		panic(err)
+	}
+	if err := ctx.WriteVarBytes(payload); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+func TestParseAttestationWithOptions(t *testing.T) {
+	tag := mustDecodeHex("0102030405060708")
+	large := encodeRawAttestation(
+		tag, bytes.Repeat([]byte{0x01}, attestationMaxPayloadSize+1),
+	)
+
+	_, err := ParseAttestation(newDeserializationContextFromBytes(large))
+	assert.Error(t, err)
+
+	att, err := ParseAttestationWithOptions(
+		newDeserializationContextFromBytes(large),
+		ParseOptions{MaxPayloadSize: 2 * attestationMaxPayloadSize},
+	)
+	require.NoError(t, err)
+	assert.Equal(t,
+		attestationMaxPayloadSize+1, len(att.(*UnknownAttestation).bytes),
+	)
+
+	_, err = ParseAttestationWithOptions(
+		newDeserializationContextFromBytes(
+			encodeRawAttestation(tag, []byte("0123456789")),
+		),
+		ParseOptions{MaxPayloadSize: 9},
+	)
+	assert.Error(t, err)
+}
+
+func TestAttestationMaxPayloadSize(t *testing.T) {
+	tag := mustDecodeHex("0102030405060708")
+
+	// a payload of exactly the maximum size is accepted and preserved
+	payload := bytes.Repeat([]byte{0x01}, attestationMaxPayloadSize)
+	data := encodeRawAttestation(tag, payload)
+	att, err := ParseAttestation(newDeserializationContextFromBytes(data))
+	require.NoError(t, err)
+	assert.Equal(t, payload, att.(*UnknownAttestation).bytes)
+	assert.Equal(t, data, encodeAttestationToBytes(t, att))
+
+	// one more byte is too large
+	data = encodeRawAttestation(tag, append(payload, 0x01))
+	_, err = ParseAttestation(newDeserializationContextFromBytes(data))
+	assert.True(t, errors.Is(err, ErrPayloadTooLarge), err)
+
+	// the same holds for a custom maximum
+	opts := ParseOptions{MaxPayloadSize: 10}
+	_, err = ParseAttestationWithOptions(
+		newDeserializationContextFromBytes(
+			encodeRawAttestation(tag, []byte("0123456789")),
+		), opts,
+	)
+	assert.NoError(t, err)
+	_, err = ParseAttestationWithOptions(
+		newDeserializationContextFromBytes(
+			encodeRawAttestation(tag, []byte("0123456789a")),
+		), opts,
+	)
+	assert.True(t, errors.Is(err, ErrPayloadTooLarge), err)
+}
+
+func TestParseBitcoinAttestationHeight(t *testing.T) {
+	parse := func(height uint64, opts ParseOptions) (Attestation, error) {
+		data := encodeAttestationToBytes(t, NewBitcoinAttestation(height))
+		return ParseAttestationWithOptions(
+			newDeserializationContextFromBytes(data), opts,
+		)
+	}
+	att, err := parse(358391, DefaultParseOptions)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(358391), att.(*BitcoinAttestation).Height())
+
+	_, err = parse(1<<63, DefaultParseOptions)
+	assert.True(t, errors.Is(err, ErrImplausibleHeight), err)
+	_, err = parse(DefaultParseOptions.MaxBitcoinHeight+1, ParseOptions{})
+	assert.True(t, errors.Is(err, ErrImplausibleHeight), err)
+
+	att, err = parse(1<<63, ParseOptions{MaxBitcoinHeight: math.MaxUint64})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1<<63), att.(*BitcoinAttestation).Height())
+}
+
+func TestParseAttestationURILength(t *testing.T) {
+	uri := "https://" + strings.Repeat("a", pendingAttestationMaxUriLength)
+	payload := &bytes.Buffer{}
+	require.NoError(t,
+		newSerializationContext(payload).WriteVarBytes([]byte(uri)),
+	)
+	data := encodeRawAttestation(pendingAttestationTag, payload.Bytes())
+
+	_, err := ParseAttestation(newDeserializationContextFromBytes(data))
+	assert.Error(t, err)
+
+	att, err := ParseAttestationWithOptions(
+		newDeserializationContextFromBytes(data),
+		ParseOptions{MaxURILength: 2 * pendingAttestationMaxUriLength},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, uri, att.(*PendingAttestation).URI())
+}
+
+// testAttestation is a custom attestation type carrying a var-bytes value.
+type testAttestation struct {
+	baseAttestation
+	value []byte
+}
+
+var testAttestationTag = mustDecodeHex("74657374aabbccdd")
+
+func (a *testAttestation) Decode(
+	ctx *DeserializationContext,
+) (Attestation, error) {
+	value, err := ctx.ReadVarBytes(0, 100)
+	if err != nil {
+		return nil, err
+	}
+	return &testAttestation{a.baseAttestation, value}, nil
+}
+
+func (a *testAttestation) Encode(ctx *SerializationContext) error {
+	return ctx.WriteVarBytes(a.value)
+}
+
+func (a *testAttestation) Equal(other Attestation) bool {
+	return sameEncoding(a, other)
+}
+
+func (a *testAttestation) String() string {
+	return fmt.Sprintf("TestAttestation(%x)", a.value)
+}
+
+// withRegisteredAttestations restores the registry after f returns.
+func withRegisteredAttestations(f func()) {
+	attestationsMu.Lock()
+	saved := append([]Attestation{}, attestations...)
+	attestationsMu.Unlock()
+	defer func() {
+		attestationsMu.Lock()
+		attestations = saved
+		attestationsMu.Unlock()
+	}()
+	f()
+}
+
+func TestRegisterAttestation(t *testing.T) {
+	data := encodeRawAttestation(testAttestationTag, []byte{0x02, 0xab, 0xcd})
+
+	att, err := ParseAttestation(newDeserializationContextFromBytes(data))
+	require.NoError(t, err)
+	assert.IsType(t, &UnknownAttestation{}, att)
+
+	withRegisteredAttestations(func() {
+		proto := &testAttestation{
+			baseAttestation: baseAttestation{testAttestationTag},
+		}
+		require.NoError(t, RegisterAttestation(proto))
+		assert.Error(t, RegisterAttestation(proto))
+		assert.Error(t, RegisterAttestation(newBitcoinAttestation()))
+		assert.Error(t, RegisterAttestation(&testAttestation{
+			baseAttestation: baseAttestation{[]byte{0x01}},
+		}))
+
+		att, err := ParseAttestation(newDeserializationContextFromBytes(data))
+		require.NoError(t, err)
+		assert.Equal(t, &testAttestation{
+			proto.baseAttestation, []byte{0xab, 0xcd},
+		}, att)
+		assert.Equal(t, data, encodeAttestationToBytes(t, att))
+	})
+}
+
+func TestDuplicateAttestationTag(t *testing.T) {
+	withRegisteredAttestations(func() {
+		conflicting := &testAttestation{
+			baseAttestation: baseAttestation{bitcoinAttestationTag},
+		}
+		err := RegisterAttestation(conflicting)
+		assert.True(t, errors.Is(err, ErrDuplicateAttestationTag), err)
+
+		// the built-in bitcoin attestation is still used
+		att, err := ParseAttestation(newDeserializationContextFromBytes(
+			encodeAttestationToBytes(t, NewBitcoinAttestation(1)),
+		))
+		require.NoError(t, err)
+		assert.Equal(t, NewBitcoinAttestation(1), att)
+	})
+
+	assert.True(t, errors.Is(
+		checkUniqueTag(
+			[]Attestation{newPendingAttestation()},
+			NewPendingAttestation("https://example.com"),
+		),
+		ErrDuplicateAttestationTag,
+	))
+	assert.NoError(t, checkUniqueTag(
+		[]Attestation{newPendingAttestation()}, newBitcoinAttestation(),
+	))
+}
+
+func TestUnknownAttestationString(t *testing.T) {
+	tag := mustDecodeHex("0102030405060708")
+	assert.Equal(t,
+		`UnknownAttestation(tag=0102030405060708, bytes="abc")`,
+		(&UnknownAttestation{tag, []byte("abc")}).String(),
+	)
+	assert.Equal(t,
+		`UnknownAttestation(tag=0102030405060708, bytes="`+
+			strings.Repeat("x", 64)+`"... (100 bytes))`,
+		(&UnknownAttestation{tag, bytes.Repeat([]byte("x"), 100)}).String(),
+	)
+}
+
+func TestAttestationTrailingBytes(t *testing.T) {
+	bitcoin := encodeRawAttestation(
+		bitcoinAttestationTag, []byte{0x01, 0xaa, 0xbb, 0xcc},
+	)
+	_, err := parseAttestationStrict(bitcoin)
+	if assert.Error(t, err) {
+		assert.True(t, errors.Is(err, ErrTrailingBytes))
+		assert.Equal(t,
+			"bitcoin attestation: 3 trailing bytes after height",
+			err.Error(),
+		)
+	}
+
+	payload := &bytes.Buffer{}
+	require.NoError(t, newSerializationContext(payload).WriteVarBytes(
+		[]byte("https://example.com"),
+	))
+	payload.WriteByte(0x00)
+	pending := encodeRawAttestation(pendingAttestationTag, payload.Bytes())
+	_, err = parseAttestationStrict(pending)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(),
+			"pending attestation: 1 trailing bytes after uri",
+		)
+	}
+
+	// lenient parsing keeps attestations with trailing bytes unparsed, so
+	// they are written back unchanged
+	for _, data := range [][]byte{bitcoin, pending} {
+		att, err := ParseAttestation(newDeserializationContextFromBytes(data))
+		require.NoError(t, err)
+		assert.IsType(t, &UnknownAttestation{}, att)
+		buf := &bytes.Buffer{}
+		require.NoError(t,
+			encodeAttestation(newSerializationContext(buf), att),
+		)
+		assert.Equal(t, data, buf.Bytes())
+	}
+}
+
+func BenchmarkParseAttestation(b *testing.B) {
+	buf := &bytes.Buffer{}
+	att := NewPendingAttestation(
+		"https://alice.btc.calendar.opentimestamps.org",
+	)
+	if err := encodeAttestation(newSerializationContext(buf), att); err != nil {
+		b.Fatal(err)
+	}
+	data := buf.Bytes()
+	// reuse the outer context to only measure ParseAttestation
+	ctx := newDeserializationContext(nil)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		ctx.Reset(bytes.NewReader(data), DefaultParseOptions)
+		if _, err := ParseAttestation(ctx); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func FuzzParseAttestation(f *testing.F) {
+	for _, path := range examplePaths() {
+		dts, err := NewDetachedTimestampFromPath(path)
+		if err != nil {
+			continue
+		}
+		dts.Timestamp.WalkAttestations(func(_ []Operation, a Attestation) {
+			buf := &bytes.Buffer{}
+			err := encodeAttestation(newSerializationContext(buf), a)
+			require.NoError(f, err)
+			f.Add(buf.Bytes())
+		})
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		att, err := ParseAttestation(newDeserializationContextFromBytes(data))
+		if err != nil {
+			return
+		}
+		encoded := encodeAttestationToBytes(t, att)
+		again, err := ParseAttestation(
+			newDeserializationContextFromBytes(encoded),
+		)
+		require.NoError(t, err)
+		assert.True(t, att.Equal(again), "%v != %v", att, again)
+	})
+}
@@ -0,0 +1,148 @@
+package opentimestamps
+
+import "fmt"
+
+// A Builder constructs a timestamp as a single path of operations from a
+// message to its attestations:
+//
+//	ts, err := NewBuilder(digest).
+//		Append(nonce).
+//		SHA256().
+//		Attest(NewPendingAttestation(uri)).
+//		Build()
+//
+// Each method records the first error and turns the remaining calls into
+// no-ops, Build returns it. Timestamps with several branches are built by
+// merging the results of several builders with Timestamp.Merge.
+type Builder struct {
+	root    *Timestamp
+	current *Timestamp
+	err     error
+}
+
+// NewBuilder returns a Builder for a timestamp of message. message is usually
+// the digest of a file, see NewTimestampFromHashedReader, and must not be
+// empty. Even an empty file has a digest.
+func NewBuilder(message []byte) *Builder {
+	ts := &Timestamp{Message: copyBytes(message)}
+	b := &Builder{root: ts, current: ts}
+	if len(message) == 0 {
+		b.err = fmt.Errorf("empty message, timestamp its digest instead")
+	}
+	return b
+}
+
+// Op executes op on the current message and continues from its result. It
+// fails if an attestation or a timestamp was already added, since they must be
+// at the end of the path.
+func (b *Builder) Op(op Operation) *Builder {
+	if b.err != nil {
+		return b
+	}
+	if len(b.current.Attestations) > 0 || len(b.current.ops) > 0 {
+		b.err = fmt.Errorf("%v after attestation: must be at a leaf", op)
+		return b
+	}
+	message, err := op.Execute(b.current.Message)
+	if err != nil {
+		b.err = err
+		return b
+	}
+	next := &Timestamp{Message: message}
+	b.current.ops = append(b.current.ops, tsLink{op, next})
+	b.current = next
+	return b
+}
+
+// binaryArg checks the argument of an append or prepend operation.
+func (b *Builder) binaryArg(name string, arg []byte) bool {
+	if b.err != nil {
+		return false
+	}
+	if len(arg) > maxBinaryArgLength {
+		b.err = fmt.Errorf(
+			"%s argument must be at most %d bytes, got %d",
+			name, maxBinaryArgLength, len(arg),
+		)
+		return false
+	}
+	return true
+}
+
+// Append appends arg to the current message.
+func (b *Builder) Append(arg []byte) *Builder {
+	if !b.binaryArg(opAppend.name, arg) {
+		return b
+	}
+	return b.Op(OpAppend(arg))
+}
+
+// Prepend prepends arg to the current message.
+func (b *Builder) Prepend(arg []byte) *Builder {
+	if !b.binaryArg(opPrepend.name, arg) {
+		return b
+	}
+	return b.Op(OpPrepend(arg))
+}
+
+// SHA256 hashes the current message with SHA256.
+func (b *Builder) SHA256() *Builder {
+	return b.Op(OpSHA256)
+}
+
+// SHA256d hashes the current message twice with SHA256, see OpSHA256d.
+func (b *Builder) SHA256d() *Builder {
+	for _, op := range OpSHA256d() {
+		b.Op(op)
+	}
+	return b
+}
+
+// Attest adds att for the current message. Several attestations can be added
+// for the same message, no operations can follow them. Block attestations
+// need a message of the size of a merkle root, so they can't be added for a
+// raw message that wasn't hashed.
+func (b *Builder) Attest(att Attestation) *Builder {
+	if b.err != nil {
+		return b
+	}
+	if att == nil {
+		b.err = fmt.Errorf("nil attestation")
+		return b
+	}
+	switch att.(type) {
+	case *BitcoinAttestation, *LitecoinAttestation, *EthereumAttestation:
+		if n := len(b.current.Message); n != hashMerkleRootSize {
+			b.err = fmt.Errorf(
+				"%v needs a %d byte message, got %d",
+				att, hashMerkleRootSize, n,
+			)
+			return b
+		}
+	}
+	b.current.Attestations = append(b.current.Attestations, att)
+	return b
+}
+
+// Merge adds the attestations and branches of ts, a timestamp for the current
+// message, e.g. the one a calendar returned for the result of the path. Like
+// attestations, no operations can follow it. ts is copied.
+func (b *Builder) Merge(ts *Timestamp) *Builder {
+	if b.err != nil {
+		return b
+	}
+	b.err = b.current.Merge(ts)
+	return b
+}
+
+// Build returns the timestamp, or the first error of the previous calls. The
+// path must end in at least one attestation.
+func (b *Builder) Build() (*Timestamp, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	if len(b.current.AllAttestations()) == 0 {
+		return nil, fmt.Errorf("timestamp has no attestation")
+	}
+	return b.root, nil
+}
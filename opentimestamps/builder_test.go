@@ -0,0 +1,117 @@
+package opentimestamps
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuilder(t *testing.T) {
+	message := []byte{0x01, 0x02}
+	ts, err := NewBuilder(message).
+		Append([]byte{0x03}).
+		Prepend([]byte{0x00}).
+		SHA256().
+		Attest(NewPendingAttestation("https://alice.example.com")).
+		Attest(NewBitcoinAttestation(1)).
+		Build()
+	require.NoError(t, err)
+
+	expected := sha256.Sum256([]byte{0x00, 0x01, 0x02, 0x03})
+	var paths [][]Operation
+	ts.WalkAttestations(func(path []Operation, a Attestation) {
+		res, err := ComputeResult(message, path)
+		require.NoError(t, err)
+		assert.Equal(t, expected[:], res)
+		paths = append(paths, path)
+	})
+	assert.Len(t, paths, 2)
+
+	data, err := ts.SerializeToBytes()
+	require.NoError(t, err)
+	parsed, err := NewTimestampFromReader(bytes.NewReader(data), message)
+	require.NoError(t, err)
+	assert.True(t, ts.Equal(parsed))
+
+	// branches are combined by merging
+	double, err := NewBuilder(message).
+		Append([]byte{0x03}).
+		SHA256d().
+		Attest(NewBitcoinAttestation(1)).
+		Build()
+	require.NoError(t, err)
+	require.NoError(t, double.Merge(ts))
+	require.Len(t, double.ops, 1)
+	assert.Len(t, double.ops[0].timestamp.ops, 2)
+}
+
+func TestBuilderMerge(t *testing.T) {
+	message := []byte{0x01, 0x02}
+	nonce := []byte{0x03}
+	commitment := sha256.Sum256([]byte{0x01, 0x02, 0x03})
+	calendar, err := NewBuilder(commitment[:]).
+		Prepend([]byte{0x00}).
+		SHA256().
+		Attest(NewPendingAttestation("https://alice.example.com")).
+		Build()
+	require.NoError(t, err)
+
+	ts, err := NewBuilder(message).
+		Append(nonce).
+		SHA256().
+		Merge(calendar).
+		Build()
+	require.NoError(t, err)
+	var paths [][]Operation
+	ts.WalkAttestations(func(path []Operation, a Attestation) {
+		paths = append(paths, path)
+	})
+	require.Len(t, paths, 1)
+	assert.Len(t, paths[0], 4)
+	leaf := ts.ops[0].timestamp.ops[0].timestamp
+	assert.True(t, calendar.Equal(leaf))
+	// merged timestamps are copied
+	leaf.ops[0].timestamp.Message[0] ^= 0xff
+	assert.False(t, calendar.Equal(leaf))
+
+	_, err = NewBuilder(message).SHA256().Merge(calendar).Build()
+	assert.Error(t, err)
+	_, err = NewBuilder(message).Append(nonce).SHA256().
+		Merge(calendar).SHA256().Build()
+	assert.Error(t, err)
+	_, err = NewBuilder(message).Merge(nil).Build()
+	assert.Error(t, err)
+}
+
+func TestBuilderErrors(t *testing.T) {
+	message := []byte{0x01, 0x02}
+	att := NewBitcoinAttestation(1)
+	for name, b := range map[string]*Builder{
+		"large argument": NewBuilder(message).
+			Append(make([]byte, maxBinaryArgLength+1)).Attest(att),
+		"large result": NewBuilder(message).
+			Append(make([]byte, maxBinaryArgLength)).Attest(att),
+		"op after attestation": NewBuilder(message).
+			SHA256().Attest(att).SHA256().Attest(att),
+		"nil attestation":  NewBuilder(message).SHA256().Attest(nil),
+		"no attestation":   NewBuilder(message).SHA256(),
+		"empty message":    NewBuilder(nil).SHA256().Attest(att),
+		"unhashed message": NewBuilder(message).Attest(att),
+		"short message": NewBuilder(message).Append([]byte{0x03}).
+			Attest(NewLitecoinAttestation(1)),
+		"short ethereum message": NewBuilder(message).
+			Attest(NewEthereumAttestation(1)),
+		"long ethereum message": NewBuilder(message).SHA256().
+			Append([]byte{0x03}).Attest(NewEthereumAttestation(1)),
+	} {
+		_, err := b.Build()
+		assert.Error(t, err, name)
+	}
+
+	_, err := NewBuilder(message).SHA256().
+		Attest(NewEthereumAttestation(1)).Build()
+	assert.NoError(t, err)
+}
@@ -0,0 +1,127 @@
+package opentimestamps
+
+import (
+	"math/rand"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// CalendarOptions configures a RemoteCalendar. Zero values are replaced by
+// the corresponding value of DefaultCalendarOptions.
+type CalendarOptions struct {
+	// MaxAttempts is the number of times a request is sent before giving
+	// up. Use 1 to disable retries.
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry.
+	BaseDelay time.Duration
+	// Multiplier is applied to the delay after every retry.
+	Multiplier float64
+	// Jitter randomizes each delay by up to this fraction in either
+	// direction, so clients don't retry in lockstep. Use NoJitter for
+	// delays without randomization.
+	Jitter float64
+	// HTTPClient is used to send requests, http.DefaultClient if nil. Use
+	// NewSOCKS5Client to route requests through Tor.
+	HTTPClient *http.Client
+	// UserAgent is sent in the User-Agent header of every request.
+	UserAgent string
+	// BearerToken authenticates requests to private calendars. It takes
+	// precedence over Username and Password.
+	BearerToken string
+	// Username and Password authenticate requests using HTTP basic auth if
+	// Username is set.
+	Username string
+	Password string
+	// Logger receives request and retry events. Nothing is logged if nil.
+	Logger Logger
+	// Limiter is waited for before every request of all calendars
+	// configured with these options, e.g. to stay within a global budget.
+	Limiter RateLimiter
+	// CalendarLimiter returns the limiter for the calendar at baseURL,
+	// which is waited for before each request to it. See
+	// PerCalendarLimiter.
+	CalendarLimiter func(baseURL string) RateLimiter
+}
+
+// NoJitter is the Jitter of CalendarOptions that retry after exactly the
+// computed delay. A Jitter of 0 is replaced by the default.
+const NoJitter = -1
+
+// DefaultCalendarOptions are used by NewRemoteCalendar.
+var DefaultCalendarOptions = CalendarOptions{
+	MaxAttempts: 3,
+	BaseDelay:   500 * time.Millisecond,
+	Multiplier:  2,
+	Jitter:      0.2,
+	UserAgent:   defaultUserAgent,
+}
+
+// withDefaults returns a copy of o with unset fields taken from
+// DefaultCalendarOptions.
+func (o CalendarOptions) withDefaults() CalendarOptions {
+	if o.MaxAttempts == 0 {
+		o.MaxAttempts = DefaultCalendarOptions.MaxAttempts
+	}
+	if o.BaseDelay == 0 {
+		o.BaseDelay = DefaultCalendarOptions.BaseDelay
+	}
+	if o.Multiplier == 0 {
+		o.Multiplier = DefaultCalendarOptions.Multiplier
+	}
+	if o.Jitter == 0 {
+		o.Jitter = DefaultCalendarOptions.Jitter
+	}
+	if o.Jitter < 0 {
+		o.Jitter = 0
+	}
+	if o.HTTPClient == nil {
+		o.HTTPClient = DefaultCalendarOptions.HTTPClient
+	}
+	if o.UserAgent == "" {
+		o.UserAgent = DefaultCalendarOptions.UserAgent
+	}
+	if o.HTTPClient == nil {
+		o.HTTPClient = http.DefaultClient
+	}
+	if o.Logger == nil {
+		o.Logger = DefaultCalendarOptions.Logger
+	}
+	o.Logger = orNop(o.Logger)
+	if o.Limiter == nil {
+		o.Limiter = DefaultCalendarOptions.Limiter
+	}
+	if o.CalendarLimiter == nil {
+		o.CalendarLimiter = DefaultCalendarOptions.CalendarLimiter
+	}
+	return o
+}
+
+// authorize adds the configured credentials to r.
+func (o CalendarOptions) authorize(r *http.Request) {
+	if o.BearerToken != "" {
+		r.Header.Set("Authorization", "Bearer "+o.BearerToken)
+	} else if o.Username != "" {
+		r.SetBasicAuth(o.Username, o.Password)
+	}
+}
+
+// retryDelay returns the delay before the given retry, starting at 1.
+func (o CalendarOptions) retryDelay(retry int) time.Duration {
+	delay := float64(o.BaseDelay)
+	for i := 1; i < retry; i++ {
+		delay *= o.Multiplier
+	}
+	delay *= 1 + o.Jitter*(2*rand.Float64()-1)
+	return time.Duration(delay)
+}
+
+// NewSOCKS5Client returns an http.Client that connects through the SOCKS5
+// proxy at addr, e.g. "127.0.0.1:9050" for a local Tor daemon. Host names are
+// resolved by the proxy.
+func NewSOCKS5Client(addr string) *http.Client {
+	proxyURL := &url.URL{Scheme: "socks5h", Host: addr}
+	return &http.Client{
+		Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)},
+	}
+}
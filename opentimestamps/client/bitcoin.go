@@ -1,12 +1,14 @@
 package client
 
 import (
+	"context"
 	"fmt"
 	"math"
 	"time"
 
 	"github.com/nginthfs/go-opentimestamps/opentimestamps"
 	btcrpcclient "github.com/btcsuite/btcd/rpcclient"
+	"github.com/btcsuite/btcd/wire"
 )
 
 // A BitcoinAttestationVerifier uses a bitcoin RPC connection to verify bitcoin
@@ -25,16 +27,12 @@ func NewBitcoinAttestationVerifier(
 // returns the time of the block if the verification succeeds, an error
 // otherwise.
 func (v *BitcoinAttestationVerifier) VerifyAttestation(
-	digest []byte, a *opentimestamps.BitcoinAttestation,
+	ctx context.Context, digest []byte, a *opentimestamps.BitcoinAttestation,
 ) (*time.Time, error) {
-	if a.Height > math.MaxInt64 {
+	if a.Height() > math.MaxInt64 {
 		return nil, fmt.Errorf("illegal block height")
 	}
-	blockHash, err := v.btcrpcClient.GetBlockHash(int64(a.Height))
-	if err != nil {
-		return nil, err
-	}
-	h, err := v.btcrpcClient.GetBlockHeader(blockHash)
+	h, err := v.blockHeader(ctx, int64(a.Height()))
 	if err != nil {
 		return nil, err
 	}
@@ -49,6 +47,34 @@ func (v *BitcoinAttestationVerifier) VerifyAttestation(
 	return &utc, nil
 }
 
+// blockHeader fetches the header of the block at height. The RPC client does
+// not support contexts, so the call is abandoned, not aborted, if ctx is done
+// first.
+func (v *BitcoinAttestationVerifier) blockHeader(
+	ctx context.Context, height int64,
+) (*wire.BlockHeader, error) {
+	type result struct {
+		header *wire.BlockHeader
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		blockHash, err := v.btcrpcClient.GetBlockHash(height)
+		if err != nil {
+			done <- result{nil, err}
+			return
+		}
+		h, err := v.btcrpcClient.GetBlockHeader(blockHash)
+		done <- result{h, err}
+	}()
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case r := <-done:
+		return r.header, r.err
+	}
+}
+
 // A BitcoinVerification is the result of verifying a BitcoinAttestation
 type BitcoinVerification struct {
 	Timestamp       *opentimestamps.Timestamp
@@ -60,7 +86,7 @@ type BitcoinVerification struct {
 // BitcoinVerifications returns the all bitcoin attestation results for the
 // timestamp.
 func (v *BitcoinAttestationVerifier) BitcoinVerifications(
-	t *opentimestamps.Timestamp,
+	ctx context.Context, t *opentimestamps.Timestamp,
 ) (res []BitcoinVerification) {
 	t.Walk(func(ts *opentimestamps.Timestamp) {
 		for _, att := range ts.Attestations {
@@ -68,7 +94,7 @@ func (v *BitcoinAttestationVerifier) BitcoinVerifications(
 			if !ok {
 				continue
 			}
-			attTime, err := v.VerifyAttestation(ts.Message, btcAtt)
+			attTime, err := v.VerifyAttestation(ctx, ts.Message, btcAtt)
 			res = append(res, BitcoinVerification{
 				Timestamp:       ts,
 				Attestation:     btcAtt,
@@ -83,9 +109,9 @@ func (v *BitcoinAttestationVerifier) BitcoinVerifications(
 // Verify returns the earliest bitcoin-attested time, or nil if none can be
 // found or verified successfully.
 func (v *BitcoinAttestationVerifier) Verify(
-	t *opentimestamps.Timestamp,
+	ctx context.Context, t *opentimestamps.Timestamp,
 ) (ret *time.Time, err error) {
-	res := v.BitcoinVerifications(t)
+	res := v.BitcoinVerifications(ctx, t)
 	for _, r := range res {
 		if r.Error != nil {
 			err = r.Error
@@ -1,7 +1,11 @@
 package client
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
 	"testing"
@@ -67,7 +71,7 @@ func TestVerifyHelloWorld(t *testing.T) {
 	verifier := BitcoinAttestationVerifier{btcConn}
 
 	// using BitcoinVerifications()
-	results := verifier.BitcoinVerifications(ts)
+	results := verifier.BitcoinVerifications(context.Background(), ts)
 	assert.Equal(t, 1, len(results))
 	result0 := results[0]
 	require.NoError(t, result0.Error)
@@ -76,8 +80,41 @@ func TestVerifyHelloWorld(t *testing.T) {
 	)
 
 	// using Verify()
-	verifiedTime, err := verifier.Verify(ts)
+	verifiedTime, err := verifier.Verify(context.Background(), ts)
 	require.NoError(t, err)
 	require.NotNil(t, verifiedTime)
 	assert.Equal(t, expectedTime, verifiedTime.Format(time.RFC3339))
 }
+
+func TestVerifyCancel(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			<-release
+		},
+	))
+	defer server.Close()
+	defer close(release)
+
+	btcConn, err := btcrpcclient.New(&btcrpcclient.ConnConfig{
+		Host:         server.Listener.Addr().String(),
+		User:         "user",
+		Pass:         "pass",
+		HTTPPostMode: true,
+		DisableTLS:   true,
+	}, nil)
+	require.NoError(t, err)
+	defer btcConn.Shutdown()
+	verifier := NewBitcoinAttestationVerifier(btcConn)
+
+	ctx, cancel := context.WithTimeout(
+		context.Background(), 50*time.Millisecond,
+	)
+	defer cancel()
+	start := time.Now()
+	_, err = verifier.VerifyAttestation(
+		ctx, make([]byte, 32), opentimestamps.NewBitcoinAttestation(1),
+	)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), err)
+	assert.True(t, time.Since(start) < 10*time.Second)
+}
@@ -0,0 +1,144 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/nginthfs/go-opentimestamps/opentimestamps"
+)
+
+// A BitcoindBackend looks up block headers using the JSON-RPC interface of a
+// bitcoind node.
+type BitcoindBackend struct {
+	// URL of the RPC server, e.g. http://localhost:8332. If empty the
+	// default RPC port of Network on localhost is used.
+	URL      string
+	User     string
+	Password string
+	// Network is the bitcoin network of the node.
+	Network opentimestamps.Network
+	// HTTPClient is used for requests, http.DefaultClient if nil.
+	HTTPClient *http.Client
+}
+
+type rpcRequest struct {
+	JSONRPC string        `json:"jsonrpc"`
+	ID      int           `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type rpcResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  *rpcError       `json:"error"`
+}
+
+// url returns the configured url or the default one for the network.
+func (b *BitcoindBackend) url() string {
+	if b.URL != "" {
+		return b.URL
+	}
+	switch b.Network {
+	case opentimestamps.NetworkTestnet:
+		return "http://localhost:18332"
+	case opentimestamps.NetworkSignet:
+		return "http://localhost:38332"
+	default:
+		return "http://localhost:8332"
+	}
+}
+
+// BitcoinNetwork implements opentimestamps.NetworkBackend.
+func (b *BitcoindBackend) BitcoinNetwork() opentimestamps.Network {
+	return b.Network
+}
+
+// call invokes method and decodes the result into result.
+func (b *BitcoindBackend) call(
+	ctx context.Context, method string, result interface{},
+	params ...interface{},
+) error {
+	body, err := json.Marshal(rpcRequest{"1.0", 1, method, params})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(
+		ctx, "POST", b.url(), bytes.NewBuffer(body),
+	)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(b.User, b.Password)
+	client := b.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// bitcoind reports RPC errors with status 500 and a JSON body
+	var rpcResp rpcResponse
+	if err := json.NewDecoder(resp.Body).Decode(&rpcResp); err != nil {
+		return fmt.Errorf("%s: unexpected response %q", method, resp.Status)
+	}
+	if rpcResp.Error != nil {
+		return fmt.Errorf(
+			"%s: rpc error %d: %s",
+			method, rpcResp.Error.Code, rpcResp.Error.Message,
+		)
+	}
+	return json.Unmarshal(rpcResp.Result, result)
+}
+
+// BlockHeader implements opentimestamps.VerificationBackend using
+// getblockhash and getblockheader.
+func (b *BitcoindBackend) BlockHeader(
+	ctx context.Context, height uint64,
+) (*opentimestamps.BlockHeader, error) {
+	var blockHash string
+	if err := b.call(ctx, "getblockhash", &blockHash, height); err != nil {
+		return nil, err
+	}
+	var header struct {
+		MerkleRoot string `json:"merkleroot"`
+		Time       int64  `json:"time"`
+	}
+	err := b.call(ctx, "getblockheader", &header, blockHash, true)
+	if err != nil {
+		return nil, err
+	}
+	merkleRoot, err := decodeReversedHex(header.MerkleRoot)
+	if err != nil {
+		return nil, fmt.Errorf("invalid merkle root: %v", err)
+	}
+	return &opentimestamps.BlockHeader{
+		MerkleRoot: merkleRoot,
+		Time:       time.Unix(header.Time, 0).UTC(),
+	}, nil
+}
+
+// decodeReversedHex decodes a hash in the reversed byte order used by the RPC
+// interface and block explorers.
+func decodeReversedHex(s string) ([]byte, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return b, nil
+}
@@ -0,0 +1,107 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nginthfs/go-opentimestamps/opentimestamps"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	// any hash works, the stub server only checks it is passed on
+	testBlockHash = "00000000000000000000000000000000" +
+		"000000000000000000000000000000ff"
+	// merkle root of block 358391 as shown by block explorers
+	helloWorldMerkleRoot = "8a1b66ecb7cbd07d8139a7e7d7f2c41a" +
+		"ab1f5009b8364aaf61d03ad245e47e00"
+)
+
+var helloWorldTime = time.Date(2015, 5, 28, 15, 41, 18, 0, time.UTC)
+
+// newTestBitcoind returns a server answering getblockhash and getblockheader
+// for the block of the hello-world example.
+func newTestBitcoind(t *testing.T) *httptest.Server {
+	return newTestNode(
+		t, 358391, testBlockHash, helloWorldMerkleRoot, helloWorldTime,
+	)
+}
+
+// newTestNode returns a JSON-RPC server that knows a single block.
+func newTestNode(
+	t *testing.T, height uint64, blockHash, merkleRoot string,
+	blockTime time.Time,
+) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			user, pass, _ := r.BasicAuth()
+			assert.Equal(t, "bitcoin", user)
+			assert.Equal(t, "secret", pass)
+			var req struct {
+				Method string        `json:"method"`
+				Params []interface{} `json:"params"`
+			}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			var result interface{}
+			switch {
+			case req.Method == "getblockhash" &&
+				req.Params[0] == float64(height):
+				result = blockHash
+			case req.Method == "getblockheader" &&
+				req.Params[0] == blockHash:
+				result = map[string]interface{}{
+					"merkleroot": merkleRoot,
+					"time":       blockTime.Unix(),
+				}
+			default:
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"result": nil,
+					"error": map[string]interface{}{
+						"code": -8, "message": "Block height out of range",
+					},
+				})
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"result": result, "error": nil,
+			})
+		},
+	))
+}
+
+func TestBitcoindBackend(t *testing.T) {
+	server := newTestBitcoind(t)
+	defer server.Close()
+	backend := &BitcoindBackend{
+		URL: server.URL, User: "bitcoin", Password: "secret",
+	}
+
+	dts, err := opentimestamps.NewDetachedTimestampFromPath(
+		"../../examples/hello-world.txt.ots",
+	)
+	require.NoError(t, err)
+	var verified bool
+	dts.Timestamp.Walk(func(ts *opentimestamps.Timestamp) {
+		for _, att := range ts.Attestations {
+			btcAtt := att.(*opentimestamps.BitcoinAttestation)
+			attTime, err := opentimestamps.VerifyBitcoinAttestation(
+				context.Background(), backend, btcAtt, ts.Message,
+			)
+			require.NoError(t, err)
+			assert.Equal(t, helloWorldTime, attTime)
+			verified = true
+		}
+	})
+	assert.True(t, verified)
+
+	_, err = backend.BlockHeader(context.Background(), 1)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "Block height out of range")
+	}
+}
@@ -0,0 +1,138 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/nginthfs/go-opentimestamps/opentimestamps"
+)
+
+const blockHeaderSize = 80
+
+// An ElectrumBackend looks up block headers from an Electrum server using
+// blockchain.block.header. Every lookup uses a new connection.
+type ElectrumBackend struct {
+	// Addr is the host:port of the server.
+	Addr string
+	// TLSConfig enables TLS if not nil. If ServerName is empty the host of
+	// Addr is used.
+	TLSConfig *tls.Config
+}
+
+func (e *ElectrumBackend) dial(ctx context.Context) (net.Conn, error) {
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", e.Addr)
+	if err != nil {
+		return nil, err
+	}
+	if e.TLSConfig == nil {
+		return conn, nil
+	}
+	cfg := e.TLSConfig.Clone()
+	if cfg.ServerName == "" {
+		host, _, err := net.SplitHostPort(e.Addr)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		cfg.ServerName = host
+	}
+	tlsConn := tls.Client(conn, cfg)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// call invokes method and decodes the result into result. The connection is
+// interrupted when ctx is done.
+func (e *ElectrumBackend) call(
+	ctx context.Context, method string, result interface{},
+	params ...interface{},
+) error {
+	conn, err := e.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+
+	req, err := json.Marshal(rpcRequest{"2.0", 1, method, params})
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Write(append(req, '\n')); err != nil {
+		return ctxErr(ctx, err)
+	}
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil {
+		return ctxErr(ctx, err)
+	}
+	var resp rpcResponse
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return fmt.Errorf("%s: invalid response: %v", method, err)
+	}
+	if resp.Error != nil {
+		return fmt.Errorf(
+			"%s: rpc error %d: %s",
+			method, resp.Error.Code, resp.Error.Message,
+		)
+	}
+	return json.Unmarshal(resp.Result, result)
+}
+
+// ctxErr returns the error of ctx if it caused err.
+func ctxErr(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// BlockHeader implements opentimestamps.VerificationBackend.
+func (e *ElectrumBackend) BlockHeader(
+	ctx context.Context, height uint64,
+) (*opentimestamps.BlockHeader, error) {
+	var headerHex string
+	err := e.call(ctx, "blockchain.block.header", &headerHex, height)
+	if err != nil {
+		return nil, err
+	}
+	header, err := hex.DecodeString(headerHex)
+	if err != nil {
+		return nil, fmt.Errorf("invalid block header: %v", err)
+	}
+	return parseBlockHeader(header)
+}
+
+// parseBlockHeader extracts the merkle root and time of a serialized block
+// header: version, previous block hash, merkle root, time, bits, nonce.
+func parseBlockHeader(header []byte) (*opentimestamps.BlockHeader, error) {
+	if len(header) != blockHeaderSize {
+		return nil, fmt.Errorf(
+			"invalid block header size %d, expected %d",
+			len(header), blockHeaderSize,
+		)
+	}
+	blockTime := binary.LittleEndian.Uint32(header[68:72])
+	return &opentimestamps.BlockHeader{
+		MerkleRoot: append([]byte{}, header[36:68]...),
+		Time:       time.Unix(int64(blockTime), 0).UTC(),
+	}, nil
+}
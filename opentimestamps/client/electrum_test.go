@@ -0,0 +1,90 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serveElectrum answers blockchain.block.header requests on l with header
+// until l is closed. If header is nil requests are never answered.
+func serveElectrum(t *testing.T, l net.Listener, header []byte) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			line, err := bufio.NewReader(conn).ReadBytes('\n')
+			if err != nil || header == nil {
+				// wait for the client to give up
+				conn.Read(make([]byte, 1))
+				return
+			}
+			var req rpcRequest
+			assert.NoError(t, json.Unmarshal(line, &req))
+			assert.Equal(t, "blockchain.block.header", req.Method)
+			json.NewEncoder(conn).Encode(map[string]interface{}{
+				"jsonrpc": "2.0",
+				"id":      req.ID,
+				"result":  hex.EncodeToString(header),
+			})
+		}(conn)
+	}
+}
+
+func newTestHeader(t *testing.T) []byte {
+	root, err := decodeReversedHex(helloWorldMerkleRoot)
+	require.NoError(t, err)
+	header := make([]byte, blockHeaderSize)
+	copy(header[36:68], root)
+	binary.LittleEndian.PutUint32(
+		header[68:72], uint32(helloWorldTime.Unix()),
+	)
+	return header
+}
+
+func TestElectrumBackend(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go serveElectrum(t, l, newTestHeader(t))
+
+	backend := &ElectrumBackend{Addr: l.Addr().String()}
+	header, err := backend.BlockHeader(context.Background(), 358391)
+	require.NoError(t, err)
+	root, err := decodeReversedHex(helloWorldMerkleRoot)
+	require.NoError(t, err)
+	assert.Equal(t, root, header.MerkleRoot)
+	assert.Equal(t, helloWorldTime, header.Time)
+
+	_, err = parseBlockHeader(make([]byte, 79))
+	assert.Error(t, err)
+}
+
+func TestElectrumBackendDeadline(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go serveElectrum(t, l, nil)
+
+	backend := &ElectrumBackend{Addr: l.Addr().String()}
+	ctx, cancel := context.WithTimeout(
+		context.Background(), 50*time.Millisecond,
+	)
+	defer cancel()
+	start := time.Now()
+	_, err = backend.BlockHeader(ctx, 358391)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), err)
+	assert.True(t, time.Since(start) < 10*time.Second)
+}
@@ -0,0 +1,114 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nginthfs/go-opentimestamps/opentimestamps"
+)
+
+// DefaultEsploraURL is the Esplora API for bitcoin mainnet run by
+// Blockstream.
+const DefaultEsploraURL = "https://blockstream.info/api"
+
+// public Esplora APIs for the networks other than mainnet
+const (
+	defaultEsploraTestnetURL = "https://blockstream.info/testnet/api"
+	defaultEsploraSignetURL  = "https://mempool.space/signet/api"
+)
+
+// An EsploraBackend looks up block headers using the REST API of an Esplora
+// block explorer.
+type EsploraBackend struct {
+	// URL is the base url of the API. If empty a public API for Network is
+	// used, DefaultEsploraURL for mainnet.
+	URL string
+	// Network is the bitcoin network of the API.
+	Network opentimestamps.Network
+	// HTTPClient is used for requests, http.DefaultClient if nil.
+	HTTPClient *http.Client
+}
+
+// baseURL returns the configured url or the default one for the network.
+func (e *EsploraBackend) baseURL() string {
+	if e.URL != "" {
+		return e.URL
+	}
+	switch e.Network {
+	case opentimestamps.NetworkTestnet:
+		return defaultEsploraTestnetURL
+	case opentimestamps.NetworkSignet:
+		return defaultEsploraSignetURL
+	default:
+		return DefaultEsploraURL
+	}
+}
+
+// get fetches path and returns the response body.
+func (e *EsploraBackend) get(ctx context.Context, path string) ([]byte, error) {
+	url := strings.TrimSuffix(e.baseURL(), "/") + path
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := e.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf(
+			"GET %s: unexpected response %q (body=%q)",
+			url, resp.Status, body,
+		)
+	}
+	return body, nil
+}
+
+// BitcoinNetwork implements opentimestamps.NetworkBackend.
+func (e *EsploraBackend) BitcoinNetwork() opentimestamps.Network {
+	return e.Network
+}
+
+// BlockHeader implements opentimestamps.VerificationBackend by looking up the
+// block hash for height and then the block.
+func (e *EsploraBackend) BlockHeader(
+	ctx context.Context, height uint64,
+) (*opentimestamps.BlockHeader, error) {
+	blockHash, err := e.get(ctx, fmt.Sprintf("/block-height/%d", height))
+	if err != nil {
+		return nil, err
+	}
+	body, err := e.get(ctx, "/block/"+strings.TrimSpace(string(blockHash)))
+	if err != nil {
+		return nil, err
+	}
+	var block struct {
+		MerkleRoot string `json:"merkle_root"`
+		Timestamp  int64  `json:"timestamp"`
+	}
+	if err := json.Unmarshal(body, &block); err != nil {
+		return nil, fmt.Errorf("invalid block: %v", err)
+	}
+	merkleRoot, err := decodeReversedHex(block.MerkleRoot)
+	if err != nil {
+		return nil, fmt.Errorf("invalid merkle root: %v", err)
+	}
+	return &opentimestamps.BlockHeader{
+		MerkleRoot: merkleRoot,
+		Time:       time.Unix(block.Timestamp, 0).UTC(),
+	}, nil
+}
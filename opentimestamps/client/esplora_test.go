@@ -0,0 +1,123 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nginthfs/go-opentimestamps/opentimestamps"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestEsplora returns a server with the block of the hello-world example
+// at its height, using merkleRoot as the merkle root.
+func newTestEsplora(merkleRoot string) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/block-height/358391",
+		func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, testBlockHash)
+		},
+	)
+	mux.HandleFunc("/api/block/"+testBlockHash,
+		func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"id":          testBlockHash,
+				"height":      358391,
+				"merkle_root": merkleRoot,
+				"timestamp":   helloWorldTime.Unix(),
+			})
+		},
+	)
+	return httptest.NewServer(mux)
+}
+
+func TestEsploraBackend(t *testing.T) {
+	server := newTestEsplora(helloWorldMerkleRoot)
+	defer server.Close()
+	backend := &EsploraBackend{URL: server.URL + "/api/"}
+
+	header, err := backend.BlockHeader(context.Background(), 358391)
+	require.NoError(t, err)
+	assert.Equal(t, helloWorldTime, header.Time)
+
+	root, err := decodeReversedHex(helloWorldMerkleRoot)
+	require.NoError(t, err)
+	attTime, err := opentimestamps.VerifyBitcoinAttestation(
+		context.Background(), backend,
+		opentimestamps.NewBitcoinAttestation(358391), root,
+	)
+	require.NoError(t, err)
+	assert.Equal(t, helloWorldTime, attTime)
+
+	_, err = backend.BlockHeader(context.Background(), 1)
+	assert.Error(t, err)
+}
+
+func TestEsploraBackendMismatch(t *testing.T) {
+	server := newTestEsplora(testBlockHash)
+	defer server.Close()
+	backend := &EsploraBackend{URL: server.URL + "/api"}
+
+	root, err := decodeReversedHex(helloWorldMerkleRoot)
+	require.NoError(t, err)
+	_, err = opentimestamps.VerifyBitcoinAttestation(
+		context.Background(), backend,
+		opentimestamps.NewBitcoinAttestation(358391), root,
+	)
+	assert.True(t, errors.Is(err, opentimestamps.ErrMerkleRootMismatch), err)
+}
+
+func TestEsploraBackendTestnet(t *testing.T) {
+	// block 1 of testnet3
+	const blockHash = "00000000b873e79784647a6c82962c70d228557d24a747ea4d1b8bbe878e1206"
+	const merkleRoot = "f0315ffc38709d70ad5647e22048358dd3745f3ce3874223c80a7c92fab0c8ba"
+	mux := http.NewServeMux()
+	mux.HandleFunc("/testnet/api/block-height/1",
+		func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, blockHash)
+		},
+	)
+	mux.HandleFunc("/testnet/api/block/"+blockHash,
+		func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, `{"id":%q,"height":1,"version":1,`+
+				`"timestamp":1296688928,"bits":486604799,`+
+				`"nonce":1924588547,"merkle_root":%q}`,
+				blockHash, merkleRoot,
+			)
+		},
+	)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	backend := &EsploraBackend{
+		URL:     server.URL + "/testnet/api",
+		Network: opentimestamps.NetworkTestnet,
+	}
+	header, err := backend.BlockHeader(context.Background(), 1)
+	require.NoError(t, err)
+	assert.Equal(t, time.Unix(1296688928, 0).UTC(), header.Time)
+	assert.Equal(t, merkleRoot, opentimestamps.ReverseHex(header.MerkleRoot))
+	assert.Equal(t, opentimestamps.NetworkTestnet, backend.BitcoinNetwork())
+
+	ts := &opentimestamps.Timestamp{Message: header.MerkleRoot}
+	ts.Attestations = []opentimestamps.Attestation{
+		opentimestamps.NewBitcoinAttestation(1),
+	}
+	results, err := ts.VerifyAll(context.Background(), backend)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, opentimestamps.VerificationConfirmed, results[0].Status)
+	assert.Equal(t, opentimestamps.NetworkTestnet, results[0].Network)
+
+	assert.Equal(t, DefaultEsploraURL, (&EsploraBackend{}).baseURL())
+	assert.Equal(t,
+		"https://blockstream.info/testnet/api",
+		(&EsploraBackend{Network: opentimestamps.NetworkTestnet}).baseURL(),
+	)
+}
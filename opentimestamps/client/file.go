@@ -0,0 +1,146 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nginthfs/go-opentimestamps/opentimestamps"
+)
+
+// A FileBackend looks up block headers in a set of trusted checkpoints, e.g.
+// read from a file that was validated independently. It doesn't use the
+// network, so it can verify timestamps on air-gapped machines.
+//
+// Checkpoints are either CSV records of height, merkle root and time:
+//
+//	# height,merkle_root,time
+//	358391,8a1b66ec...45e47e00,1432827678
+//
+// or a JSON array of objects with the same fields:
+//
+//	[{"height": 358391, "merkle_root": "8a1b66ec...", "time": 1432827678}]
+//
+// Merkle roots are hex in the byte order shown by block explorers and times
+// are unix timestamps in seconds. Lines starting with # are ignored in CSV.
+type FileBackend struct {
+	// Network is the bitcoin network of the checkpoints.
+	Network opentimestamps.Network
+	headers map[uint64]opentimestamps.BlockHeader
+}
+
+// checkpoint is a JSON record of a FileBackend.
+type checkpoint struct {
+	Height     uint64 `json:"height"`
+	MerkleRoot string `json:"merkle_root"`
+	Time       int64  `json:"time"`
+}
+
+// NewFileBackend reads the checkpoints in the file at path.
+func NewFileBackend(path string) (*FileBackend, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	backend, err := NewFileBackendFromReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", path, err)
+	}
+	return backend, nil
+}
+
+// NewFileBackendFromReader reads checkpoints from r. The format is detected
+// from the content, JSON if it starts with [ and CSV otherwise.
+func NewFileBackendFromReader(r io.Reader) (*FileBackend, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var checkpoints []checkpoint
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		err = json.Unmarshal(data, &checkpoints)
+	} else {
+		checkpoints, err = readCheckpointsCSV(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, err
+	}
+	backend := &FileBackend{
+		headers: make(map[uint64]opentimestamps.BlockHeader),
+	}
+	for _, c := range checkpoints {
+		if _, ok := backend.headers[c.Height]; ok {
+			return nil, fmt.Errorf(
+				"duplicate checkpoint for block %d", c.Height,
+			)
+		}
+		merkleRoot, err := decodeReversedHex(c.MerkleRoot)
+		if err != nil || len(merkleRoot) != 32 {
+			return nil, fmt.Errorf(
+				"invalid merkle root %q for block %d", c.MerkleRoot, c.Height,
+			)
+		}
+		backend.headers[c.Height] = opentimestamps.BlockHeader{
+			MerkleRoot: merkleRoot,
+			Time:       time.Unix(c.Time, 0).UTC(),
+		}
+	}
+	return backend, nil
+}
+
+func readCheckpointsCSV(r io.Reader) ([]checkpoint, error) {
+	reader := csv.NewReader(bufio.NewReader(r))
+	reader.Comment = '#'
+	reader.FieldsPerRecord = 3
+	reader.TrimLeadingSpace = true
+	var checkpoints []checkpoint
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return checkpoints, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+		height, err := strconv.ParseUint(record[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid height: %v", line, err)
+		}
+		blockTime, err := strconv.ParseInt(record[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid time: %v", line, err)
+		}
+		checkpoints = append(checkpoints, checkpoint{
+			height, strings.TrimSpace(record[1]), blockTime,
+		})
+	}
+}
+
+// BitcoinNetwork implements opentimestamps.NetworkBackend.
+func (f *FileBackend) BitcoinNetwork() opentimestamps.Network {
+	return f.Network
+}
+
+// BlockHeader implements opentimestamps.VerificationBackend. Blocks without a
+// checkpoint are an error.
+func (f *FileBackend) BlockHeader(
+	ctx context.Context, height uint64,
+) (*opentimestamps.BlockHeader, error) {
+	header, ok := f.headers[height]
+	if !ok {
+		return nil, fmt.Errorf("no checkpoint for block %d", height)
+	}
+	header.MerkleRoot = append([]byte{}, header.MerkleRoot...)
+	return &header, nil
+}
@@ -0,0 +1,52 @@
+package client
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/nginthfs/go-opentimestamps/opentimestamps"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileBackend(t *testing.T) {
+	dts, err := opentimestamps.NewDetachedTimestampFromPath(
+		"../../examples/hello-world.txt.ots",
+	)
+	require.NoError(t, err)
+
+	for _, path := range []string{
+		"../../examples/checkpoints.csv",
+		"../../examples/checkpoints.json",
+	} {
+		backend, err := NewFileBackend(path)
+		require.NoError(t, err, path)
+		attTime, err := dts.Timestamp.Verify(context.Background(), backend)
+		require.NoError(t, err, path)
+		assert.Equal(t, helloWorldTime, attTime, path)
+
+		_, err = backend.BlockHeader(context.Background(), 1)
+		assert.Error(t, err, path)
+	}
+
+	// a checkpoint with another merkle root fails verification
+	backend, err := NewFileBackendFromReader(strings.NewReader(
+		"358391," + strings.Repeat("00", 32) + ",1432827678\n",
+	))
+	require.NoError(t, err)
+	_, err = dts.Timestamp.Verify(context.Background(), backend)
+	assert.Error(t, err)
+
+	for _, invalid := range []string{
+		"358391,00,1432827678\n",
+		"x," + helloWorldMerkleRoot + ",1432827678\n",
+		"358391," + helloWorldMerkleRoot + "\n",
+		"358391," + helloWorldMerkleRoot + ",1\n" +
+			"358391," + helloWorldMerkleRoot + ",1\n",
+		`[{"height": "358391"}]`,
+	} {
+		_, err := NewFileBackendFromReader(strings.NewReader(invalid))
+		assert.Error(t, err, invalid)
+	}
+}
@@ -0,0 +1,59 @@
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nginthfs/go-opentimestamps/opentimestamps"
+)
+
+// Default RPC addresses of a local litecoind.
+const (
+	DefaultLitecoindURL        = "http://localhost:9332"
+	DefaultLitecoindTestnetURL = "http://localhost:19332"
+)
+
+// A LitecoindBackend looks up litecoin block headers using the JSON-RPC
+// interface of litecoind. It is compatible with the one of bitcoind, and
+// litecoin headers have the same layout, so only the chain differs.
+//
+// Network selects the litecoin network of the same name. If URL is empty,
+// DefaultLitecoindURL or DefaultLitecoindTestnetURL is used. Litecoin has no
+// signet, a URL must be set for it.
+type LitecoindBackend struct {
+	BitcoindBackend
+}
+
+// Chain implements opentimestamps.ChainBackend, so the backend is used for
+// litecoin attestations.
+func (l *LitecoindBackend) Chain() opentimestamps.Chain {
+	return opentimestamps.ChainLitecoin
+}
+
+func (l *LitecoindBackend) url() (string, error) {
+	if l.URL != "" {
+		return l.URL, nil
+	}
+	switch l.Network {
+	case opentimestamps.NetworkMainnet:
+		return DefaultLitecoindURL, nil
+	case opentimestamps.NetworkTestnet:
+		return DefaultLitecoindTestnetURL, nil
+	default:
+		return "", fmt.Errorf("no default litecoind url for %v", l.Network)
+	}
+}
+
+// BlockHeader implements opentimestamps.VerificationBackend.
+func (l *LitecoindBackend) BlockHeader(
+	ctx context.Context, height uint64,
+) (*opentimestamps.BlockHeader, error) {
+	url, err := l.url()
+	if err != nil {
+		return nil, err
+	}
+	// the bitcoind client is used with the litecoin default filled in
+	b := l.BitcoindBackend
+	b.URL = url
+	return b.BlockHeader(ctx, height)
+}
@@ -0,0 +1,86 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/nginthfs/go-opentimestamps/opentimestamps"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// the litecoin genesis block
+const (
+	litecoinGenesisHash = "12a765e31ffd4059bada1e25190f6e98" +
+		"c99d9714d334efa41a195a7e7e04bfe2"
+	litecoinGenesisMerkleRoot = "97ddfbbae6be97fd6cdf3e7ca13232a3" +
+		"afff2353e29badfab7f73011edd4ced9"
+)
+
+var litecoinGenesisTime = time.Unix(1317972665, 0).UTC()
+
+func TestLitecoindBackend(t *testing.T) {
+	server := newTestNode(
+		t, 0, litecoinGenesisHash, litecoinGenesisMerkleRoot,
+		litecoinGenesisTime,
+	)
+	defer server.Close()
+	backend := &LitecoindBackend{BitcoindBackend{
+		URL: server.URL, User: "bitcoin", Password: "secret",
+	}}
+
+	root, err := decodeReversedHex(litecoinGenesisMerkleRoot)
+	require.NoError(t, err)
+	ts := &opentimestamps.Timestamp{
+		Message: root,
+		Attestations: []opentimestamps.Attestation{
+			opentimestamps.NewLitecoinAttestation(0),
+		},
+	}
+	attTime, err := ts.Verify(context.Background(), backend)
+	require.NoError(t, err)
+	assert.Equal(t, litecoinGenesisTime, attTime)
+
+	// a bitcoin backend is not used for litecoin attestations
+	_, err = ts.Verify(context.Background(), &backend.BitcoindBackend)
+	assert.True(t, errors.Is(err, opentimestamps.ErrIncomplete), err)
+}
+
+// recordingTransport records the hosts of requests and fails them.
+type recordingTransport struct {
+	hosts []string
+}
+
+func (r *recordingTransport) RoundTrip(
+	req *http.Request,
+) (*http.Response, error) {
+	r.hosts = append(r.hosts, req.URL.Host)
+	return nil, errors.New("not connected")
+}
+
+func TestLitecoindBackendDefaultURL(t *testing.T) {
+	transport := &recordingTransport{}
+	client := &http.Client{Transport: transport}
+	for _, network := range []opentimestamps.Network{
+		opentimestamps.NetworkMainnet, opentimestamps.NetworkTestnet,
+	} {
+		backend := &LitecoindBackend{BitcoindBackend{
+			Network: network, HTTPClient: client,
+		}}
+		_, err := backend.BlockHeader(context.Background(), 0)
+		assert.Error(t, err)
+	}
+	assert.Equal(t,
+		[]string{"localhost:9332", "localhost:19332"}, transport.hosts,
+	)
+
+	backend := &LitecoindBackend{BitcoindBackend{
+		Network: opentimestamps.NetworkSignet, HTTPClient: client,
+	}}
+	_, err := backend.BlockHeader(context.Background(), 0)
+	assert.Error(t, err)
+	assert.Len(t, transport.hosts, 2)
+}
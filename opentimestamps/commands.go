@@ -1,26 +1,18 @@
 package opentimestamps
 
-import (
-	"crypto/sha256"
-	"io"
-	"os"
-)
+import "context"
 
 func CreateDetachedTimestampForFile(
-	path string, cal *RemoteCalendar,
+	ctx context.Context, path string, cal *RemoteCalendar,
 ) (*DetachedTimestamp, error) {
-	f, err := os.Open(path)
+	fileTs, err := NewTimestampFromFile(path)
 	if err != nil {
 		return nil, err
 	}
-	hasher := sha256.New()
-	if _, err := io.Copy(hasher, f); err != nil {
-		return nil, err
-	}
-	digest := hasher.Sum([]byte{})
-	ts, err := cal.Submit(digest)
+	digest := fileTs.Message
+	ts, err := cal.Submit(ctx, digest)
 	if err != nil {
 		return nil, err
 	}
-	return NewDetachedTimestamp(*opSHA256, digest, ts)
+	return NewDetachedTimestamp(*OpSHA256, digest, ts)
 }
@@ -21,33 +21,68 @@ type DetachedTimestamp struct {
 	Timestamp *Timestamp
 }
 
+// Dump returns the hash of the file and the tree of its timestamp, see
+// Timestamp.Dump.
 func (d *DetachedTimestamp) Dump() string {
 	w := &bytes.Buffer{}
 	fmt.Fprintf(
-		w, "File %s hash: %x\n", d.HashOp.name, d.Timestamp.Message,
+		w, "File %s hash: %x\nTimestamp:\n",
+		d.HashOp.name, d.Timestamp.Message,
 	)
 	fmt.Fprint(w, d.Timestamp.Dump())
 	return w.String()
 }
 
-func (d *DetachedTimestamp) encode(ctx *serializationContext) error {
-	if err := ctx.writeBytes(fileHeaderMagic); err != nil {
+func (d *DetachedTimestamp) encode(ctx *SerializationContext) error {
+	if err := ctx.WriteBytes(fileHeaderMagic); err != nil {
 		return err
 	}
-	if err := ctx.writeVarUint(fileMajorVersion); err != nil {
+	if err := ctx.WriteVarUint(fileMajorVersion); err != nil {
 		return err
 	}
 	if err := d.HashOp.encode(ctx); err != nil {
 		return err
 	}
-	if err := ctx.writeBytes(d.FileHash); err != nil {
+	if err := ctx.WriteBytes(d.FileHash); err != nil {
 		return err
 	}
 	return d.Timestamp.encode(ctx)
 }
 
 func (d *DetachedTimestamp) WriteToStream(w io.Writer) error {
-	return d.encode(&serializationContext{w})
+	return d.encode(newSerializationContext(w))
+}
+
+// WriteTo implements io.WriterTo, it writes d like WriteToStream and returns
+// the number of bytes written.
+func (d *DetachedTimestamp) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	err := d.encode(newSerializationContext(cw))
+	return cw.n, err
+}
+
+// ReadFrom implements io.ReaderFrom, it replaces d with the detached
+// timestamp read from r with DefaultParseOptions. r must end after the
+// timestamp, otherwise ErrTrailingBytes is returned. d is only modified if
+// parsing succeeds, the returned count includes the bytes read either way.
+func (d *DetachedTimestamp) ReadFrom(r io.Reader) (int64, error) {
+	ctx := getDeserializationContext(r, DefaultParseOptions)
+	defer putDeserializationContext(ctx)
+	parsed, err := newDetachedTimestampFromContext(ctx)
+	if err == nil && !ctx.assertEOF() {
+		err = fmt.Errorf("%w after detached timestamp", ErrTrailingBytes)
+	}
+	if err != nil {
+		return ctx.offset, err
+	}
+	*d = *parsed
+	return ctx.offset, nil
+}
+
+// SerializeCanonical writes d like WriteToStream, with the timestamp encoded
+// by Timestamp.SerializeCanonical.
+func (d *DetachedTimestamp) SerializeCanonical(w io.Writer) error {
+	return d.encode(&SerializationContext{w: w, canonical: true})
 }
 
 func NewDetachedTimestamp(
@@ -63,22 +98,38 @@ func NewDetachedTimestamp(
 }
 
 func NewDetachedTimestampFromReader(r io.Reader) (*DetachedTimestamp, error) {
-	ctx := newDeserializationContext(r)
+	return ParseTimestampFromReader(r, DefaultParseOptions)
+}
+
+// ParseTimestampFromReader parses a detached timestamp file while reading it
+// from r, e.g. an upload request body, without buffering it first. Reading
+// stops with ErrReadLimitExceeded after opts.MaxReadBytes.
+func ParseTimestampFromReader(
+	r io.Reader, opts ParseOptions,
+) (*DetachedTimestamp, error) {
+	ctx := getDeserializationContext(r, opts)
+	defer putDeserializationContext(ctx)
+	return newDetachedTimestampFromContext(ctx)
+}
+
+func newDetachedTimestampFromContext(
+	ctx *DeserializationContext,
+) (*DetachedTimestamp, error) {
 	if err := ctx.assertMagic([]byte(fileHeaderMagic)); err != nil {
 		return nil, err
 	}
-	major, err := ctx.readVarUint()
+	major, err := ctx.ReadVarUint()
 	if err != nil {
 		return nil, err
 	}
 	if major != uint64(fileMajorVersion) {
-		return nil, fmt.Errorf("unexpected major version %d", major)
+		return nil, &UnsupportedVersionError{Version: major}
 	}
 	fileHashOp, err := parseCryptOp(ctx)
 	if err != nil {
 		return nil, err
 	}
-	fileHash, err := ctx.readBytes(fileHashOp.digestLength)
+	fileHash, err := ctx.ReadBytes(fileHashOp.digestLength)
 	if err != nil {
 		return nil, err
 	}
@@ -89,6 +140,106 @@ func NewDetachedTimestampFromReader(r io.Reader) (*DetachedTimestamp, error) {
 	return &DetachedTimestamp{*fileHashOp, fileHash, ts}, nil
 }
 
+// A ParseResult is the outcome of ParseLenientCollect.
+type ParseResult struct {
+	// Timestamp holds everything that could be parsed. It is nil if the
+	// file header is invalid.
+	Timestamp *DetachedTimestamp
+	// Errors lists the problems in the order they were found. The last one
+	// stopped the parse if it was not recoverable.
+	Errors []error
+}
+
+// Err returns the errors of r as a MultiError, or nil if there are none.
+func (r *ParseResult) Err() error {
+	if len(r.Errors) == 0 {
+		return nil
+	}
+	return MultiError(r.Errors)
+}
+
+// ParseLenientCollect parses a detached timestamp file like
+// ParseTimestampFromReader, but continues past recoverable problems and
+// returns everything it could parse. Attestations that fail to decode are
+// kept as *UnknownAttestation with their original bytes. If parsing stops
+// early, the branches read so far are kept, except for the ones without
+// attestations. opts.Strict is ignored.
+func ParseLenientCollect(r io.Reader, opts ParseOptions) *ParseResult {
+	opts.Strict = false
+	ctx := getDeserializationContext(r, opts)
+	defer putDeserializationContext(ctx)
+	ctx.collect = true
+
+	result := &ParseResult{}
+	if err := ctx.assertMagic([]byte(fileHeaderMagic)); err != nil {
+		result.Errors = []error{err}
+		return result
+	}
+	major, err := ctx.ReadVarUint()
+	if err == nil && major != uint64(fileMajorVersion) {
+		err = &UnsupportedVersionError{Version: major}
+	}
+	if err != nil {
+		result.Errors = []error{err}
+		return result
+	}
+	fileHashOp, err := parseCryptOp(ctx)
+	if err != nil {
+		result.Errors = []error{err}
+		return result
+	}
+	fileHash, err := ctx.ReadBytes(fileHashOp.digestLength)
+	if err != nil {
+		result.Errors = []error{err}
+		return result
+	}
+
+	ts := &Timestamp{Message: fileHash}
+	err = parse(ts, ctx, fileHash, ctx.opts.MaxOpDepth)
+	result.Errors = ctx.errs
+	if err != nil {
+		result.Errors = append(result.Errors, err)
+		ts.dropUnattested()
+	}
+	result.Timestamp = &DetachedTimestamp{*fileHashOp, fileHash, ts}
+	return result
+}
+
+// Attestations returns every attestation of the timestamp of d, see
+// Timestamp.AllAttestations.
+func (d *DetachedTimestamp) Attestations() []Attestation {
+	return d.Timestamp.AllAttestations()
+}
+
+// MatchesFile reports whether the file at path has the digest d was created
+// for. The file is hashed with the hash operation in the header of d.
+func (d *DetachedTimestamp) MatchesFile(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	digest, err := d.HashOp.ExecuteReader(f)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(digest, d.FileHash), nil
+}
+
+// ReadDetachedTimestampFile reads a detached timestamp in the format of the
+// .ots files written by the reference client. ErrUnknownMagic is returned if
+// r does not start with the file header.
+func ReadDetachedTimestampFile(r io.Reader) (*DetachedTimestamp, error) {
+	return NewDetachedTimestampFromReader(r)
+}
+
+// WriteDetachedTimestampFile writes d including the file header and version,
+// the output can be read by ReadDetachedTimestampFile and the reference
+// client.
+func WriteDetachedTimestampFile(w io.Writer, d *DetachedTimestamp) error {
+	return d.WriteToStream(w)
+}
+
 func NewDetachedTimestampFromPath(p string) (*DetachedTimestamp, error) {
 	f, err := os.Open(p)
 	if err != nil {
@@ -2,12 +2,21 @@ package opentimestamps
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
 	"encoding/hex"
+	"errors"
+	"io"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"path/filepath"
 	"testing"
+	"testing/iotest"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func examplePaths() []string {
@@ -18,17 +27,6 @@ func examplePaths() []string {
 	return matches
 }
 
-func containsUnknownAttestation(ts *Timestamp) (res bool) {
-	ts.Walk(func(subTs *Timestamp) {
-		for _, att := range subTs.Attestations {
-			if _, ok := att.(unknownAttestation); ok {
-				res = true
-			}
-		}
-	})
-	return
-}
-
 func TestDecodeHelloWorld(t *testing.T) {
 	dts, err := NewDetachedTimestampFromPath(
 		"../examples/hello-world.txt.ots",
@@ -39,8 +37,7 @@ func TestDecodeHelloWorld(t *testing.T) {
 	checkAttestation := func(ts *Timestamp, att Attestation) {
 		assert.Equal(t, 0, attCount)
 
-		expectedAtt := newBitcoinAttestation()
-		expectedAtt.Height = 358391
+		expectedAtt := NewBitcoinAttestation(358391)
 		assert.Equal(t, expectedAtt, att)
 
 		// If ts.Message is correct, opcode parsing and execution should
@@ -69,13 +66,8 @@ func TestDecodeEncodeAll(t *testing.T) {
 		dts, err := NewDetachedTimestampFromPath(path)
 		assert.NoError(t, err, path)
 
-		if containsUnknownAttestation(dts.Timestamp) {
-			t.Logf("skipping encode cycle: unknownAttestation")
-			continue
-		}
-
 		buf := &bytes.Buffer{}
-		err = dts.Timestamp.encode(&serializationContext{buf})
+		err = dts.Timestamp.encode(&SerializationContext{w: buf})
 		if !assert.NoError(t, err, path) {
 			continue
 		}
@@ -108,3 +100,375 @@ func TestDecodeEncodeAll(t *testing.T) {
 		t.Log("encode cycle success")
 	}
 }
+
+func TestReadLimit(t *testing.T) {
+	data, err := ioutil.ReadFile("../examples/hello-world.txt.ots")
+	require.NoError(t, err)
+
+	for _, limit := range []int64{1, 64, int64(len(data)) - 1} {
+		ctx := NewDeserializationContext(
+			bytes.NewBuffer(data), ParseOptions{MaxReadBytes: limit},
+		)
+		_, err := newDetachedTimestampFromContext(ctx)
+		assert.True(t, errors.Is(err, ErrReadLimitExceeded), err)
+	}
+
+	ctx := NewDeserializationContext(
+		bytes.NewBuffer(data), ParseOptions{MaxReadBytes: int64(len(data))},
+	)
+	_, err = newDetachedTimestampFromContext(ctx)
+	assert.NoError(t, err)
+}
+
+func TestReadWriteDetachedTimestampFile(t *testing.T) {
+	data, err := ioutil.ReadFile("../examples/hello-world.txt.ots")
+	require.NoError(t, err)
+
+	dts, err := ReadDetachedTimestampFile(bytes.NewBuffer(data))
+	require.NoError(t, err)
+	buf := &bytes.Buffer{}
+	require.NoError(t, WriteDetachedTimestampFile(buf, dts))
+	assert.Equal(t, data, buf.Bytes())
+	assert.True(t, bytes.HasPrefix(buf.Bytes(), fileHeaderMagic))
+
+	corrupt := append([]byte{}, data...)
+	corrupt[1] = 'X'
+	_, err = ReadDetachedTimestampFile(bytes.NewBuffer(corrupt))
+	assert.True(t, errors.Is(err, ErrUnknownMagic), err)
+
+	corrupt = append([]byte{}, data...)
+	corrupt[len(fileHeaderMagic)] = fileMajorVersion + 1
+	_, err = ReadDetachedTimestampFile(bytes.NewBuffer(corrupt))
+	assert.True(t, errors.Is(err, ErrUnsupportedVersion), err)
+	var versionErr *UnsupportedVersionError
+	if assert.True(t, errors.As(err, &versionErr), err) {
+		assert.Equal(t, uint64(fileMajorVersion+1), versionErr.Version)
+	}
+}
+
+func TestParseTimestampFromReader(t *testing.T) {
+	data, err := ioutil.ReadFile("../examples/two-calendars.txt.ots")
+	require.NoError(t, err)
+	want, err := NewDetachedTimestampFromReader(bytes.NewBuffer(data))
+	require.NoError(t, err)
+
+	dts, err := ParseTimestampFromReader(
+		iotest.OneByteReader(bytes.NewBuffer(data)), ParseOptions{},
+	)
+	require.NoError(t, err)
+	assert.True(t, want.Timestamp.Equal(dts.Timestamp))
+
+	_, err = ParseTimestampFromReader(
+		iotest.OneByteReader(bytes.NewBuffer(data)),
+		ParseOptions{MaxReadBytes: 100},
+	)
+	assert.True(t, errors.Is(err, ErrReadLimitExceeded), err)
+}
+
+func BenchmarkParseDetachedTimestamp(b *testing.B) {
+	data, err := ioutil.ReadFile("../examples/two-calendars.txt.ots")
+	require.NoError(b, err)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := ParseTimestampFromReader(
+			bytes.NewReader(data), DefaultParseOptions,
+		); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestMatchesFile(t *testing.T) {
+	dts, err := NewDetachedTimestampFromPath("../examples/hello-world.txt.ots")
+	require.NoError(t, err)
+	ok, err := dts.MatchesFile("../examples/hello-world.txt")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = dts.MatchesFile("../examples/incomplete.txt")
+	require.NoError(t, err)
+	assert.False(t, ok)
+	_, err = dts.MatchesFile("../examples/missing")
+	assert.Error(t, err)
+
+	// the hash op of the file is used, not SHA256
+	dir, err := ioutil.TempDir("", "ots-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "file.txt")
+	require.NoError(t, ioutil.WriteFile(path, []byte("hello"), 0644))
+	digest, err := msgSHA1([]byte("hello"))
+	require.NoError(t, err)
+	dts, err = NewDetachedTimestamp(*OpSHA1, digest, &Timestamp{
+		Message: digest,
+	})
+	require.NoError(t, err)
+	ok, err = dts.MatchesFile(path)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	message := dts.Timestamp.MessageDigest()
+	assert.Equal(t, digest, message)
+	message[0] ^= 0xff
+	assert.Equal(t, digest, dts.Timestamp.Message)
+}
+
+func TestStampEmptyFile(t *testing.T) {
+	// the calendar confirms submissions right away, in block 1
+	var merkleRoot []byte
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			digest, err := ioutil.ReadAll(r.Body)
+			assert.NoError(t, err)
+			ts, err := NewBuilder(digest).
+				Append([]byte{0x01}).
+				SHA256().
+				Attest(NewBitcoinAttestation(1)).
+				Build()
+			if !assert.NoError(t, err) {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			sum := sha256.Sum256(append(digest, 0x01))
+			merkleRoot = sum[:]
+			assert.NoError(t, ts.Serialize(w))
+		},
+	))
+	defer server.Close()
+	cal := newTestCalendar(server.URL)
+
+	dir, err := ioutil.TempDir("", "ots-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "empty")
+	require.NoError(t, ioutil.WriteFile(path, nil, 0644))
+
+	dts, err := CreateDetachedTimestampForFile(
+		context.Background(), path, cal,
+	)
+	require.NoError(t, err)
+	emptyDigest := sha256.Sum256(nil)
+	assert.Equal(t, emptyDigest[:], dts.FileHash)
+
+	var buf bytes.Buffer
+	require.NoError(t, dts.WriteToStream(&buf))
+	parsed, err := NewDetachedTimestampFromReader(&buf)
+	require.NoError(t, err)
+	ok, err := parsed.MatchesFile(path)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	require.NoError(t, parsed.Timestamp.ValidateStructure())
+	attTime, err := parsed.Timestamp.Verify(context.Background(), testBackend{
+		1: {MerkleRoot: merkleRoot, Time: helloWorldTime},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, helloWorldTime, attTime)
+
+	// the empty message itself can't be timestamped
+	_, err = cal.Submit(context.Background(), nil)
+	assert.Error(t, err)
+	err = (&Timestamp{
+		Attestations: []Attestation{NewPendingAttestation(server.URL)},
+	}).ValidateStructure()
+	assert.True(t, errors.Is(err, ErrInvalidStructure), err)
+}
+
+func FuzzParseTimestamp(f *testing.F) {
+	for _, path := range examplePaths() {
+		data, err := ioutil.ReadFile(path)
+		require.NoError(f, err)
+		f.Add(data)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		dts, err := NewDetachedTimestampFromReader(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		var buf bytes.Buffer
+		require.NoError(t, dts.WriteToStream(&buf))
+		again, err := NewDetachedTimestampFromReader(&buf)
+		require.NoError(t, err)
+		assert.True(t, sameOp(&dts.HashOp, &again.HashOp))
+		assert.Equal(t, dts.FileHash, again.FileHash)
+		assert.True(t, dts.Timestamp.Equal(again.Timestamp))
+	})
+}
+
+func TestParseStrict(t *testing.T) {
+	digest := sha256.Sum256([]byte("strict"))
+	detached := func(ts ...byte) []byte {
+		data := append([]byte{}, fileHeaderMagic...)
+		data = append(data, fileMajorVersion, OpSHA256.tag)
+		data = append(data, digest[:]...)
+		return append(data, ts...)
+	}
+	bitcoin := append([]byte{0x00}, bitcoinAttestationTag...)
+	uri := "ftp://example.com"
+	pending := append([]byte{0x00}, pendingAttestationTag...)
+	pending = append(pending, byte(len(uri)+1), byte(len(uri)))
+	pending = append(pending, uri...)
+	for name, tc := range map[string]struct {
+		data []byte
+		att  Attestation
+	}{
+		// height 1 encoded as 0x81 0x00
+		"non-canonical var-uint": {detached(append(
+			[]byte{OpSHA256.tag}, append(bitcoin, 0x02, 0x81, 0x00)...,
+		)...), NewBitcoinAttestation(1)},
+		// a zero byte after the height, kept unparsed
+		"trailing bytes": {detached(append(
+			[]byte{OpSHA256.tag}, append(bitcoin, 0x02, 0x01, 0x00)...,
+		)...), &UnknownAttestation{
+			tagBytes: bitcoinAttestationTag, bytes: []byte{0x01, 0x00},
+		}},
+		"uri scheme": {
+			detached(append([]byte{OpSHA256.tag}, pending...)...),
+			NewPendingAttestation(uri),
+		},
+	} {
+		dts, err := ParseTimestampFromReader(
+			bytes.NewReader(tc.data), ParseOptions{},
+		)
+		require.NoError(t, err, name)
+		atts := dts.Attestations()
+		require.Len(t, atts, 1, name)
+		assert.True(t, tc.att.Equal(atts[0]), name)
+
+		_, err = ParseTimestampFromReader(
+			bytes.NewReader(tc.data), ParseOptions{Strict: true},
+		)
+		assert.Error(t, err, name)
+	}
+
+	// APPEND with an empty argument is accepted in both modes
+	data := detached(append(
+		[]byte{opAppend.tag, 0x00, OpSHA256.tag},
+		append(bitcoin, 0x01, 0x01)...,
+	)...)
+	for _, opts := range []ParseOptions{{}, {Strict: true}} {
+		dts, err := ParseTimestampFromReader(bytes.NewReader(data), opts)
+		require.NoError(t, err)
+		assert.Len(t, dts.Attestations(), 1)
+	}
+}
+
+func TestParseLenientCollect(t *testing.T) {
+	digest := sha256.Sum256([]byte("lenient"))
+	detached := func(ts ...byte) []byte {
+		data := append([]byte{}, fileHeaderMagic...)
+		data = append(data, fileMajorVersion, OpSHA256.tag)
+		data = append(data, digest[:]...)
+		return append(data, ts...)
+	}
+	bitcoin := func(height byte) []byte {
+		att := append([]byte{0x00}, bitcoinAttestationTag...)
+		return append(att, 0x01, height)
+	}
+	// a height cut off inside a complete payload
+	broken := append([]byte{0x00}, bitcoinAttestationTag...)
+	broken = append(broken, 0x01, 0x80)
+
+	var tree []byte
+	tree = append(tree, 0xff)
+	tree = append(tree, bitcoin(1)...)
+	tree = append(tree, 0xff)
+	tree = append(tree, broken...)
+	tree = append(tree, OpSHA256.tag)
+	tree = append(tree, bitcoin(2)...)
+	data := detached(tree...)
+
+	_, err := ParseTimestampFromReader(bytes.NewReader(data), ParseOptions{})
+	require.Error(t, err)
+
+	result := ParseLenientCollect(bytes.NewReader(data), ParseOptions{})
+	require.Len(t, result.Errors, 1)
+	assert.True(t, errors.Is(result.Errors[0], ErrUnexpectedEOF))
+	require.NotNil(t, result.Timestamp)
+	atts := result.Timestamp.Attestations()
+	require.Len(t, atts, 3)
+	assert.True(t, NewBitcoinAttestation(1).Equal(atts[0]))
+	assert.IsType(t, &UnknownAttestation{}, atts[1])
+	assert.True(t, NewBitcoinAttestation(2).Equal(atts[2]))
+	// the salvaged attestation is written back unchanged
+	var buf bytes.Buffer
+	require.NoError(t, result.Timestamp.WriteToStream(&buf))
+	assert.Equal(t, data, buf.Bytes())
+
+	// a truncated branch is dropped, the complete one is kept
+	truncated := detached(append(
+		append([]byte{0xff}, bitcoin(1)...), OpSHA256.tag,
+	)...)
+	result = ParseLenientCollect(bytes.NewReader(truncated), ParseOptions{})
+	require.Len(t, result.Errors, 1)
+	assert.True(t, errors.Is(result.Err(), ErrUnexpectedEOF))
+	atts = result.Timestamp.Attestations()
+	require.Len(t, atts, 1)
+	assert.True(t, NewBitcoinAttestation(1).Equal(atts[0]))
+
+	result = ParseLenientCollect(
+		bytes.NewReader(bytes.Repeat([]byte{0x01}, 64)), ParseOptions{},
+	)
+	assert.Nil(t, result.Timestamp)
+	assert.True(t, errors.Is(result.Err(), ErrUnknownMagic))
+
+	result = ParseLenientCollect(
+		bytes.NewReader(detached(bitcoin(1)...)), ParseOptions{},
+	)
+	assert.NoError(t, result.Err())
+}
+
+// shortWriter accepts at most max bytes per write without reporting an error.
+type shortWriter struct {
+	max int
+}
+
+func (s shortWriter) Write(p []byte) (int, error) {
+	if len(p) > s.max {
+		return s.max, nil
+	}
+	return len(p), nil
+}
+
+func TestWriterToReaderFrom(t *testing.T) {
+	orig, err := ioutil.ReadFile("../examples/two-calendars.txt.ots")
+	require.NoError(t, err)
+
+	var dts DetachedTimestamp
+	n, err := dts.ReadFrom(iotest.HalfReader(bytes.NewReader(orig)))
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(orig)), n)
+	assert.Len(t, dts.Attestations(), 2)
+
+	var buf bytes.Buffer
+	n, err = dts.WriteTo(iotest.TruncateWriter(&buf, 1<<20))
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(orig)), n)
+	assert.Equal(t, orig, buf.Bytes())
+
+	_, err = dts.WriteTo(shortWriter{1})
+	assert.True(t, errors.Is(err, io.ErrShortWrite), err)
+
+	// the timestamp without the header, for the file hash
+	tsBytes, err := dts.Timestamp.SerializeToBytes()
+	require.NoError(t, err)
+	ts := &Timestamp{Message: dts.Timestamp.Message}
+	n, err = ts.ReadFrom(iotest.OneByteReader(bytes.NewReader(tsBytes)))
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(tsBytes)), n)
+	assert.True(t, dts.Timestamp.Equal(ts))
+	buf.Reset()
+	n, err = ts.WriteTo(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(tsBytes)), n)
+	assert.Equal(t, tsBytes, buf.Bytes())
+
+	// trailing data and parse errors leave the timestamp unchanged
+	_, err = dts.ReadFrom(bytes.NewReader(append(orig, 0x00)))
+	assert.True(t, errors.Is(err, ErrTrailingBytes), err)
+	_, err = ts.ReadFrom(bytes.NewReader(tsBytes[:len(tsBytes)-1]))
+	assert.True(t, errors.Is(err, ErrUnexpectedEOF), err)
+	assert.Len(t, dts.Attestations(), 2)
+	assert.Len(t, ts.AllAttestations(), 2)
+
+	var _ io.WriterTo = &dts
+	var _ io.ReaderFrom = ts
+}
@@ -0,0 +1,122 @@
+package opentimestamps
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Errors returned while parsing timestamps. They are wrapped with additional
+// context, use errors.Is to test for them.
+var (
+	// ErrUnexpectedEOF is returned if the data ends in the middle of a value.
+	ErrUnexpectedEOF = errors.New("unexpected EOF")
+	// ErrPayloadTooLarge is returned if a length prefix exceeds the
+	// allowed maximum.
+	ErrPayloadTooLarge = errors.New("payload too large")
+	// ErrTrailingBytes is returned if an attestation payload contains more
+	// bytes than its type consumes.
+	ErrTrailingBytes = errors.New("trailing bytes")
+	// ErrUnknownMagic is returned if the file header magic does not match.
+	ErrUnknownMagic = errors.New("unknown magic bytes")
+	// ErrReadLimitExceeded is returned if parsing would consume more than
+	// ParseOptions.MaxReadBytes.
+	ErrReadLimitExceeded = errors.New("read limit exceeded")
+	// ErrDuplicateAttestationTag is returned when two attestation prototypes
+	// share the same tag.
+	ErrDuplicateAttestationTag = errors.New("duplicate attestation tag")
+	// ErrDuplicateOpTag is returned when two operations share the same
+	// tag.
+	ErrDuplicateOpTag = errors.New("duplicate operation tag")
+	// ErrOpDepthExceeded is returned if operations are nested deeper than
+	// ParseOptions.MaxOpDepth.
+	ErrOpDepthExceeded = errors.New("operation depth exceeded")
+	// ErrUnsupportedVersion is returned if a detached timestamp file has a
+	// major version other than 1. The returned error is an
+	// *UnsupportedVersionError.
+	ErrUnsupportedVersion = errors.New("unsupported version")
+	// ErrImplausibleHeight is returned if a bitcoin attestation claims a
+	// block height above ParseOptions.MaxBitcoinHeight.
+	ErrImplausibleHeight = errors.New("implausible block height")
+	// ErrUnexpectedMarker is returned if a timestamp node starts with a byte
+	// that is neither an attestation marker nor the tag of an operation, or
+	// if a fork marker is followed by another one.
+	ErrUnexpectedMarker = errors.New("unexpected marker")
+)
+
+// Errors returned by calendar clients.
+var (
+	// ErrCommitmentNotFound is returned if a calendar doesn't know a
+	// commitment (yet). For pending attestations this usually means the
+	// calendar has not been confirmed in a block.
+	ErrCommitmentNotFound = errors.New("commitment not found")
+	// ErrUnauthorized is returned if a calendar rejects the configured
+	// credentials or requires some.
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrUnexpectedContentType is returned if a calendar responds with
+	// something other than a timestamp, e.g. an HTML error page.
+	ErrUnexpectedContentType = errors.New("unexpected content type")
+	// ErrCalendarInfoUnsupported is returned by RemoteCalendar.Info if the
+	// calendar doesn't publish its metadata.
+	ErrCalendarInfoUnsupported = errors.New("calendar info unsupported")
+)
+
+// Errors returned while verifying attestations.
+var (
+	// ErrMerkleRootMismatch is returned if the message of an attestation is
+	// not the merkle root of the attested block.
+	ErrMerkleRootMismatch = errors.New("merkle root mismatch")
+	// ErrIncomplete is returned if a timestamp has no attestation that can
+	// be verified, usually because it needs to be upgraded.
+	ErrIncomplete = errors.New("timestamp incomplete")
+	// ErrUnknownAttestation is returned if a timestamp has attestations of
+	// unknown types and VerifyOptions.RejectUnknown is set.
+	ErrUnknownAttestation = errors.New("unknown attestation")
+	// ErrInvalidStructure is returned by Timestamp.ValidateStructure if the
+	// operations of a timestamp don't lead to the messages it records.
+	ErrInvalidStructure = errors.New("invalid timestamp structure")
+)
+
+// eofError is returned if a read hits the end of the stream. It matches both
+// io.EOF, which is expected at the end of a stream, and ErrUnexpectedEOF,
+// which is what it means when a value was expected.
+type eofError struct{}
+
+func (eofError) Error() string {
+	return io.EOF.Error()
+}
+
+func (eofError) Is(target error) bool {
+	return target == io.EOF || target == ErrUnexpectedEOF
+}
+
+// UnsupportedVersionError carries the version of a detached timestamp file
+// that could not be parsed. It matches ErrUnsupportedVersion.
+type UnsupportedVersionError struct {
+	Version uint64
+}
+
+func (e *UnsupportedVersionError) Error() string {
+	return fmt.Sprintf("%v %d", ErrUnsupportedVersion, e.Version)
+}
+
+func (e *UnsupportedVersionError) Is(target error) bool {
+	return target == ErrUnsupportedVersion
+}
+
+// A MultiError holds several errors, e.g. the ones collected by
+// ParseLenientCollect. errors.Is and errors.As match any of them.
+type MultiError []error
+
+func (m MultiError) Error() string {
+	msgs := make([]string, len(m))
+	for i, err := range m {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d errors: %s", len(m), strings.Join(msgs, "; "))
+}
+
+func (m MultiError) Unwrap() []error {
+	return m
+}
@@ -0,0 +1,107 @@
+package opentimestamps_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/nginthfs/go-opentimestamps/opentimestamps"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeserializationContextExported(t *testing.T) {
+	data := []byte{0x03, 'a', 'b', 'c', 0xac, 0x02, 0x01, 0x02}
+	ctx := opentimestamps.NewDeserializationContext(
+		bytes.NewReader(data), opentimestamps.DefaultParseOptions,
+	)
+	b, err := ctx.ReadVarBytes(0, 3)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("abc"), b)
+	v, err := ctx.ReadVarUint()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(300), v)
+	b, err = ctx.ReadBytes(2)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x01, 0x02}, b)
+	_, err = ctx.ReadBytes(1)
+	assert.True(t, errors.Is(err, opentimestamps.ErrUnexpectedEOF), err)
+}
+
+// noteAttestation is an attestation type defined outside of the package,
+// carrying a short note.
+type noteAttestation struct {
+	note []byte
+}
+
+var noteAttestationTag = []byte("note\x01\x02\x03\x04")
+
+func (n *noteAttestation) String() string {
+	return fmt.Sprintf("NoteAttestation(%q)", n.note)
+}
+
+func (n *noteAttestation) Tag() []byte {
+	return append([]byte{}, noteAttestationTag...)
+}
+
+func (n *noteAttestation) Decode(
+	ctx *opentimestamps.DeserializationContext,
+) (opentimestamps.Attestation, error) {
+	note, err := ctx.ReadVarBytes(1, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &noteAttestation{append([]byte{}, note...)}, nil
+}
+
+func (n *noteAttestation) Encode(
+	ctx *opentimestamps.SerializationContext,
+) error {
+	return ctx.WriteVarBytes(n.note)
+}
+
+func (n *noteAttestation) Equal(other opentimestamps.Attestation) bool {
+	o, ok := other.(*noteAttestation)
+	return ok && bytes.Equal(n.note, o.note)
+}
+
+// registerNote registers noteAttestation once per test binary, the registry
+// can't be reset from outside the package.
+var registerNote sync.Once
+
+func TestRegisterAttestationExternal(t *testing.T) {
+	registerNote.Do(func() {
+		require.NoError(t, opentimestamps.RegisterAttestation(
+			&noteAttestation{},
+		))
+	})
+	err := opentimestamps.RegisterAttestation(&noteAttestation{})
+	assert.True(t,
+		errors.Is(err, opentimestamps.ErrDuplicateAttestationTag), err,
+	)
+
+	digest := sha256.Sum256([]byte("external"))
+	ts, err := opentimestamps.NewBuilder(digest[:]).
+		SHA256().
+		Attest(&noteAttestation{[]byte("hello")}).
+		Build()
+	require.NoError(t, err)
+	data, err := ts.SerializeToBytes()
+	require.NoError(t, err)
+
+	parsed, err := opentimestamps.NewTimestampFromReader(
+		bytes.NewReader(data), digest[:],
+	)
+	require.NoError(t, err)
+	atts := parsed.AllAttestations()
+	require.Len(t, atts, 1)
+	require.IsType(t, &noteAttestation{}, atts[0])
+	assert.Equal(t, []byte("hello"), atts[0].(*noteAttestation).note)
+	assert.True(t, ts.Equal(parsed))
+	again, err := parsed.SerializeToBytes()
+	require.NoError(t, err)
+	assert.Equal(t, data, again)
+}
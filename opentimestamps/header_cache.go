@@ -0,0 +1,134 @@
+package opentimestamps
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// A HeaderCache stores block headers looked up by verification backends, so
+// proofs for the same block don't query the backend again. It evicts the
+// least recently used headers beyond its size and headers older than its ttl.
+// A HeaderCache is safe for concurrent use and can be shared by several
+// backends, see NewCachedBackend.
+type HeaderCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[headerKey]*list.Element
+	// most recently used first
+	lru *list.List
+	now func() time.Time
+}
+
+// headerKey identifies a block across chains and networks.
+type headerKey struct {
+	chain   Chain
+	network Network
+	height  uint64
+}
+
+type headerEntry struct {
+	key     headerKey
+	header  BlockHeader
+	expires time.Time
+}
+
+// NewHeaderCache returns a cache holding at most size headers, each for at
+// most ttl. A ttl of 0 keeps headers until they are evicted.
+func NewHeaderCache(size int, ttl time.Duration) *HeaderCache {
+	return &HeaderCache{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[headerKey]*list.Element),
+		lru:     list.New(),
+		now:     time.Now,
+	}
+}
+
+func (c *HeaderCache) get(key headerKey) (*BlockHeader, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*headerEntry)
+	if c.ttl > 0 && !c.now().Before(entry.expires) {
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	header := entry.header
+	header.MerkleRoot = copyBytes(header.MerkleRoot)
+	return &header, true
+}
+
+func (c *HeaderCache) put(key headerKey, header *BlockHeader) {
+	if c.size <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := &headerEntry{key, *header, c.now().Add(c.ttl)}
+	entry.header.MerkleRoot = copyBytes(header.MerkleRoot)
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = entry
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*headerEntry).key)
+	}
+}
+
+// Len returns the number of cached headers, including expired ones that have
+// not been evicted yet.
+func (c *HeaderCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// cachedBackend looks up headers in a cache before asking its backend.
+type cachedBackend struct {
+	backend VerificationBackend
+	cache   *HeaderCache
+}
+
+// NewCachedBackend returns a backend that looks up block headers in cache
+// before querying backend, and stores the headers backend returns. The
+// returned backend keeps the chain and network of backend.
+func NewCachedBackend(
+	backend VerificationBackend, cache *HeaderCache,
+) VerificationBackend {
+	return &cachedBackend{backend, cache}
+}
+
+func (c *cachedBackend) BlockHeader(
+	ctx context.Context, height uint64,
+) (*BlockHeader, error) {
+	key := headerKey{c.Chain(), c.BitcoinNetwork(), height}
+	if header, ok := c.cache.get(key); ok {
+		return header, nil
+	}
+	header, err := c.backend.BlockHeader(ctx, height)
+	if err != nil {
+		return nil, err
+	}
+	c.cache.put(key, header)
+	return header, nil
+}
+
+func (c *cachedBackend) Chain() Chain {
+	return backendChain(c.backend)
+}
+
+func (c *cachedBackend) BitcoinNetwork() Network {
+	return backendNetwork(c.backend)
+}
@@ -0,0 +1,120 @@
+package opentimestamps
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingBackend counts the lookups of the backend it wraps.
+type countingBackend struct {
+	VerificationBackend
+	calls int32
+}
+
+func (c *countingBackend) BlockHeader(
+	ctx context.Context, height uint64,
+) (*BlockHeader, error) {
+	atomic.AddInt32(&c.calls, 1)
+	return c.VerificationBackend.BlockHeader(ctx, height)
+}
+
+// countingLitecoin is a countingBackend for litecoin.
+type countingLitecoin struct {
+	*countingBackend
+}
+
+func (countingLitecoin) Chain() Chain {
+	return ChainLitecoin
+}
+
+func TestCachedBackend(t *testing.T) {
+	ctx := context.Background()
+	dts, err := NewDetachedTimestampFromPath("../examples/hello-world.txt.ots")
+	require.NoError(t, err)
+	counting := &countingBackend{VerificationBackend: newTestBackend()}
+	cache := NewHeaderCache(10, time.Hour)
+	backend := NewCachedBackend(counting, cache)
+
+	for i := 0; i < 2; i++ {
+		attTime, err := dts.Timestamp.Verify(ctx, backend)
+		require.NoError(t, err)
+		assert.Equal(t, helloWorldTime, attTime)
+	}
+	assert.Equal(t, int32(1), counting.calls)
+
+	// the cache is shared with other backends for the same chain
+	other := &countingBackend{VerificationBackend: newTestBackend()}
+	_, err = dts.Timestamp.Verify(ctx, NewCachedBackend(other, cache))
+	require.NoError(t, err)
+	assert.Equal(t, int32(0), other.calls)
+
+	// but not with other chains
+	litecoin := &countingBackend{VerificationBackend: newTestBackend()}
+	cachedLitecoin := NewCachedBackend(countingLitecoin{litecoin}, cache)
+	assert.Equal(t, ChainLitecoin, backendChain(cachedLitecoin))
+	_, err = cachedLitecoin.BlockHeader(ctx, 358391)
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), litecoin.calls)
+
+	// errors are not cached
+	_, err = backend.BlockHeader(ctx, 1)
+	assert.Error(t, err)
+	_, err = backend.BlockHeader(ctx, 1)
+	assert.Error(t, err)
+	assert.Equal(t, int32(3), counting.calls)
+}
+
+func TestHeaderCacheEviction(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := NewHeaderCache(2, time.Minute)
+	cache.now = func() time.Time { return now }
+	key := func(height uint64) headerKey {
+		return headerKey{ChainBitcoin, NetworkMainnet, height}
+	}
+	header := &BlockHeader{MerkleRoot: []byte{0x01}, Time: now}
+
+	cache.put(key(1), header)
+	cache.put(key(2), header)
+	_, ok := cache.get(key(1))
+	assert.True(t, ok)
+	// 2 is the least recently used
+	cache.put(key(3), header)
+	assert.Equal(t, 2, cache.Len())
+	_, ok = cache.get(key(2))
+	assert.False(t, ok)
+
+	// cached headers can't be modified through the returned copy
+	cached, ok := cache.get(key(1))
+	require.True(t, ok)
+	cached.MerkleRoot[0] = 0xff
+	cached, _ = cache.get(key(1))
+	assert.Equal(t, []byte{0x01}, cached.MerkleRoot)
+
+	now = now.Add(time.Minute)
+	_, ok = cache.get(key(1))
+	assert.False(t, ok)
+	assert.Equal(t, 1, cache.Len())
+}
+
+func TestHeaderCacheConcurrent(t *testing.T) {
+	counting := &countingBackend{VerificationBackend: newTestBackend()}
+	backend := NewCachedBackend(counting, NewHeaderCache(1, 0))
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := backend.BlockHeader(context.Background(), 358391)
+			assert.NoError(t, err)
+			_, err = backend.BlockHeader(context.Background(), 1)
+			assert.Error(t, err)
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,24 @@
+package opentimestamps
+
+// Logger receives events from calendar clients and verification, e.g. retried
+// requests or skipped attestations. *logrus.Logger implements it.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+}
+
+// nopLogger discards all events. It is used when no Logger is configured.
+type nopLogger struct{}
+
+func (nopLogger) Debugf(format string, args ...interface{}) {}
+func (nopLogger) Infof(format string, args ...interface{})  {}
+func (nopLogger) Warnf(format string, args ...interface{})  {}
+
+// orNop returns l, or a nopLogger if l is nil.
+func orNop(l Logger) Logger {
+	if l == nil {
+		return nopLogger{}
+	}
+	return l
+}
@@ -0,0 +1,43 @@
+package opentimestamps
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// recordingLogger stores the events it receives, prefixed with their level.
+type recordingLogger struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (l *recordingLogger) logf(level, format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, level+" "+fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Debugf(format string, args ...interface{}) {
+	l.logf("debug", format, args...)
+}
+
+func (l *recordingLogger) Infof(format string, args ...interface{}) {
+	l.logf("info", format, args...)
+}
+
+func (l *recordingLogger) Warnf(format string, args ...interface{}) {
+	l.logf("warn", format, args...)
+}
+
+// matching returns the events containing substr.
+func (l *recordingLogger) matching(substr string) (res []string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, e := range l.events {
+		if strings.Contains(e, substr) {
+			res = append(res, e)
+		}
+	}
+	return
+}
@@ -1,16 +1,26 @@
 package opentimestamps
 
 import (
+	"bytes"
 	"crypto/sha1"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"hash"
+	"io"
+	"sync"
 
 	"golang.org/x/crypto/ripemd160"
+	"golang.org/x/crypto/sha3"
 )
 
+// maxResultLength limits the size of messages produced by operations.
 const maxResultLength = 4096
 
+// maxBinaryArgLength limits the argument of opAppend and opPrepend. Longer
+// arguments could never produce a valid result.
+const maxBinaryArgLength = maxResultLength
+
 type unaryMsgOp func(message []byte) ([]byte, error)
 type binaryMsgOp func(message, argument []byte) ([]byte, error)
 
@@ -66,11 +76,34 @@ func msgSHA256(msg []byte) ([]byte, error) {
 	return res[:], nil
 }
 
-type opCode interface {
+// msgKeccak256 uses the original Keccak padding as Ethereum does, which
+// differs from the standardized SHA3-256.
+func msgKeccak256(msg []byte) ([]byte, error) {
+	h := sha3.NewLegacyKeccak256()
+	_, err := h.Write(msg)
+	if err != nil {
+		return nil, err
+	}
+	return h.Sum([]byte{}), nil
+}
+
+// An Operation transforms a message into the message of the next timestamp.
+type Operation interface {
+	fmt.Stringer
 	match(byte) bool
-	decode(*deserializationContext) (opCode, error)
-	encode(*serializationContext) error
-	apply(message []byte) ([]byte, error)
+	decode(*DeserializationContext) (Operation, error)
+	encode(*SerializationContext) error
+	// Execute returns the result of applying the operation to message.
+	Execute(message []byte) ([]byte, error)
+}
+
+// A StreamingOperation can be executed on a message that is read from a
+// stream instead of held in memory. The hash operations implement it.
+type StreamingOperation interface {
+	Operation
+	// ExecuteReader returns the result of applying the operation to
+	// everything read from r.
+	ExecuteReader(r io.Reader) ([]byte, error)
 }
 
 type op struct {
@@ -95,16 +128,16 @@ func (u *unaryOp) String() string {
 	return u.name
 }
 
-func (u *unaryOp) decode(ctx *deserializationContext) (opCode, error) {
+func (u *unaryOp) decode(ctx *DeserializationContext) (Operation, error) {
 	ret := *u
 	return &ret, nil
 }
 
-func (u *unaryOp) encode(ctx *serializationContext) error {
+func (u *unaryOp) encode(ctx *SerializationContext) error {
 	return ctx.writeByte(u.tag)
 }
 
-func (u *unaryOp) apply(message []byte) ([]byte, error) {
+func (u *unaryOp) Execute(message []byte) ([]byte, error) {
 	return u.msgOp(message)
 }
 
@@ -113,27 +146,41 @@ func (u *unaryOp) apply(message []byte) ([]byte, error) {
 type cryptOp struct {
 	unaryOp
 	digestLength int
+	// newHash is used to hash streams that don't fit in memory
+	newHash func() hash.Hash
 }
 
 func newCryptOp(
 	tag byte, name string, msgOp unaryMsgOp, digestLength int,
+	newHash func() hash.Hash,
 ) *cryptOp {
 	return &cryptOp{
 		unaryOp:      *newUnaryOp(tag, name, msgOp),
 		digestLength: digestLength,
+		newHash:      newHash,
 	}
 }
 
-func (c *cryptOp) decode(ctx *deserializationContext) (opCode, error) {
+// ExecuteReader returns the digest of everything read from r. The input is
+// hashed as it is read, so its size doesn't matter.
+func (c *cryptOp) ExecuteReader(r io.Reader) ([]byte, error) {
+	h := c.newHash()
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, err
+	}
+	return h.Sum([]byte{}), nil
+}
+
+func (c *cryptOp) decode(ctx *DeserializationContext) (Operation, error) {
 	u, err := c.unaryOp.decode(ctx)
 	if err != nil {
 		return nil, err
 	}
-	return &cryptOp{*u.(*unaryOp), c.digestLength}, nil
+	return &cryptOp{*u.(*unaryOp), c.digestLength, c.newHash}, nil
 }
 
 // Binary operations
-// We decode an extra varbyte argument and use it in apply()
+// We decode an extra varbyte argument and use it in Execute()
 
 type binaryOp struct {
 	op
@@ -149,27 +196,39 @@ func newBinaryOp(tag byte, name string, msgOp binaryMsgOp) *binaryOp {
 	}
 }
 
-func (b *binaryOp) decode(ctx *deserializationContext) (opCode, error) {
-	arg, err := ctx.readVarBytes(0, maxResultLength)
+// withArgument returns a copy of the prototype b using arg.
+func (b *binaryOp) withArgument(arg []byte) *binaryOp {
+	ret := *b
+	ret.argument = copyBytes(arg)
+	return &ret
+}
+
+func (b *binaryOp) decode(ctx *DeserializationContext) (Operation, error) {
+	// an empty argument leaves the message unchanged but is kept, so the
+	// operation is encoded again as it was found
+	arg, err := ctx.ReadVarBytes(0, maxBinaryArgLength)
 	if err != nil {
-		return nil, err
-	}
-	if len(arg) == 0 {
-		return nil, fmt.Errorf("empty argument invalid for binaryOp")
+		return nil, fmt.Errorf("%s argument: %w", b.name, err)
 	}
 	ret := *b
 	ret.argument = arg
 	return &ret, nil
 }
 
-func (b *binaryOp) encode(ctx *serializationContext) error {
+func (b *binaryOp) encode(ctx *SerializationContext) error {
 	if err := ctx.writeByte(b.tag); err != nil {
 		return err
 	}
-	return ctx.writeVarBytes(b.argument)
+	return ctx.WriteVarBytes(b.argument)
 }
 
-func (b *binaryOp) apply(message []byte) ([]byte, error) {
+func (b *binaryOp) Execute(message []byte) ([]byte, error) {
+	if len(message)+len(b.argument) > maxResultLength {
+		return nil, fmt.Errorf(
+			"%s result length %d exceeds %d",
+			b.name, len(message)+len(b.argument), maxResultLength,
+		)
+	}
 	return b.msgOp(message, b.argument)
 }
 
@@ -177,31 +236,166 @@ func (b *binaryOp) String() string {
 	return fmt.Sprintf("%s %x", b.name, b.argument)
 }
 
+// OpAppend returns an operation that appends arg to the message.
+func OpAppend(arg []byte) Operation {
+	return opAppend.withArgument(arg)
+}
+
+// OpPrepend returns an operation that prepends arg to the message.
+func OpPrepend(arg []byte) Operation {
+	return opPrepend.withArgument(arg)
+}
+
+// Operations that don't take an argument. Binary operations are created with
+// OpAppend and OpPrepend.
 var (
 	opAppend    = newBinaryOp(0xf0, "APPEND", msgAppend)
 	opPrepend   = newBinaryOp(0xf1, "PREPEND", msgPrepend)
-	opReverse   = newUnaryOp(0xf2, "REVERSE", msgReverse)
-	opHexlify   = newUnaryOp(0xf3, "HEXLIFY", msgHexlify)
-	opSHA1      = newCryptOp(0x02, "SHA1", msgSHA1, 20)
-	opRIPEMD160 = newCryptOp(0x03, "RIPEMD160", msgRIPEMD160, 20)
-	opSHA256    = newCryptOp(0x08, "SHA256", msgSHA256, 32)
+	OpReverse   = newUnaryOp(0xf2, "REVERSE", msgReverse)
+	OpHexlify   = newUnaryOp(0xf3, "HEXLIFY", msgHexlify)
+	OpSHA1      = newCryptOp(0x02, "SHA1", msgSHA1, 20, sha1.New)
+	OpRIPEMD160 = newCryptOp(
+		0x03, "RIPEMD160", msgRIPEMD160, 20, ripemd160.New,
+	)
+	OpSHA256    = newCryptOp(0x08, "SHA256", msgSHA256, 32, sha256.New)
+	OpKeccak256 = newCryptOp(
+		0x67, "KECCAK256", msgKeccak256, 32, sha3.NewLegacyKeccak256,
+	)
+)
+
+// OpSHA256d returns the double SHA256 used by bitcoin for block and
+// transaction hashes. The format has no single operation for it, so it is
+// the path of two SHA256 operations, e.g. for ComputeResult.
+func OpSHA256d() []Operation {
+	return []Operation{OpSHA256, OpSHA256}
+}
+
+var (
+	// opCodesMu guards opCodes, which can be extended via RegisterHashOp
+	// while other goroutines are parsing.
+	opCodesMu sync.RWMutex
+	opCodes   []Operation = []Operation{
+		opAppend, opPrepend, OpReverse, OpHexlify, OpSHA1, OpRIPEMD160,
+		OpSHA256, OpKeccak256,
+	}
 )
 
-var opCodes []opCode = []opCode{
-	opAppend, opPrepend, opReverse, opHexlify, opSHA1, opRIPEMD160,
-	opSHA256,
+func init() {
+	for i, o := range opCodes {
+		if err := checkUniqueOpTag(opCodes[:i], opTag(o)); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// opTag returns the tag of one of the operation types of this package.
+func opTag(o Operation) byte {
+	switch o := o.(type) {
+	case *unaryOp:
+		return o.tag
+	case *cryptOp:
+		return o.tag
+	case *binaryOp:
+		return o.tag
+	default:
+		panic(fmt.Sprintf("unexpected operation %T", o))
+	}
+}
+
+// checkUniqueOpTag returns an error if one of ops has tag.
+func checkUniqueOpTag(ops []Operation, tag byte) error {
+	for _, o := range ops {
+		if o.match(tag) {
+			return fmt.Errorf(
+				"%w: %02x already registered by %v",
+				ErrDuplicateOpTag, tag, o,
+			)
+		}
+	}
+	return nil
+}
+
+// RegisterHashOp adds a hash operation with tag to the operations recognized
+// when parsing, e.g. for the proofs of a private calendar using another hash
+// function. The returned operation hashes messages with the hashes returned
+// by newHash. The tags of the fork and attestation markers can't be used,
+// registering a tag that is already known returns ErrDuplicateOpTag.
+func RegisterHashOp(
+	tag byte, name string, newHash func() hash.Hash,
+) (StreamingOperation, error) {
+	if tag == forkMarker || tag == attestationMarker {
+		return nil, fmt.Errorf("operation tag %02x is reserved", tag)
+	}
+	if name == "" {
+		return nil, fmt.Errorf("operation %02x needs a name", tag)
+	}
+	msgOp := func(msg []byte) ([]byte, error) {
+		h := newHash()
+		if _, err := h.Write(msg); err != nil {
+			return nil, err
+		}
+		return h.Sum([]byte{}), nil
+	}
+	hashOp := newCryptOp(tag, name, msgOp, newHash().Size(), newHash)
+	opCodesMu.Lock()
+	defer opCodesMu.Unlock()
+	if err := checkUniqueOpTag(opCodes, tag); err != nil {
+		return nil, err
+	}
+	opCodes = append(opCodes, hashOp)
+	return hashOp, nil
+}
+
+// ComputeResult executes the operations of path in order, starting with
+// message. For the path to a bitcoin attestation the result is the merkle
+// root of the block in the byte order of the block header, block explorers
+// show it reversed.
+func ComputeResult(message []byte, path []Operation) ([]byte, error) {
+	res := copyBytes(message)
+	for _, op := range path {
+		var err error
+		if res, err = op.Execute(res); err != nil {
+			return nil, fmt.Errorf("%v: %w", op, err)
+		}
+	}
+	return res, nil
 }
 
-func parseOp(ctx *deserializationContext, tag byte) (opCode, error) {
+// sameOp reports whether a and b have the same encoding, i.e. the same tag and
+// argument.
+func sameOp(a, b Operation) bool {
+	bufA, bufB := &bytes.Buffer{}, &bytes.Buffer{}
+	if a.encode(newSerializationContext(bufA)) != nil {
+		return false
+	}
+	if b.encode(newSerializationContext(bufB)) != nil {
+		return false
+	}
+	return bytes.Equal(bufA.Bytes(), bufB.Bytes())
+}
+
+// findOpCode returns the registered operation for tag, or nil.
+func findOpCode(tag byte) Operation {
+	opCodesMu.RLock()
+	defer opCodesMu.RUnlock()
 	for _, op := range opCodes {
 		if op.match(tag) {
-			return op.decode(ctx)
+			return op
 		}
 	}
-	return nil, fmt.Errorf("could not decode tag %02x", tag)
+	return nil
+}
+
+func parseOp(ctx *DeserializationContext, tag byte) (Operation, error) {
+	if op := findOpCode(tag); op != nil {
+		return op.decode(ctx)
+	}
+	return nil, fmt.Errorf(
+		"%w: could not decode tag %02x", ErrUnexpectedMarker, tag,
+	)
 }
 
-func parseCryptOp(ctx *deserializationContext) (*cryptOp, error) {
+func parseCryptOp(ctx *DeserializationContext) (*cryptOp, error) {
 	tag, err := ctx.readByte()
 	if err != nil {
 		return nil, err
@@ -1,10 +1,15 @@
 package opentimestamps
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/hex"
+	"errors"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestMsgAppend(t *testing.T) {
@@ -64,6 +69,7 @@ func TestMsgSHA256(t *testing.T) {
 
 func TestRIPEMD160(t *testing.T) {
 	out, err := msgRIPEMD160([]byte{})
+	assert.NoError(t, err)
 	assert.Equal(t,
 		"9c1185a5c5e9fc54612808977ee8f548b2258d31",
 		hex.EncodeToString(out),
@@ -76,3 +82,264 @@ func TestRIPEMD160(t *testing.T) {
 		hex.EncodeToString(out),
 	)
 }
+
+func TestParseSHA1Op(t *testing.T) {
+	op, err := parseCryptOp(newDeserializationContextFromBytes([]byte{0x02}))
+	assert.NoError(t, err)
+	assert.Equal(t, "SHA1", op.String())
+	assert.Equal(t, 20, op.digestLength)
+
+	out, err := op.Execute([]byte{})
+	assert.NoError(t, err)
+	assert.Equal(t,
+		"da39a3ee5e6b4b0d3255bfef95601890afd80709",
+		hex.EncodeToString(out),
+	)
+}
+
+func TestParseEncodeRIPEMD160Op(t *testing.T) {
+	op, err := parseCryptOp(newDeserializationContextFromBytes([]byte{0x03}))
+	assert.NoError(t, err)
+	assert.Equal(t, "RIPEMD160", op.String())
+	assert.Equal(t, 20, op.digestLength)
+
+	out, err := op.Execute([]byte{})
+	assert.NoError(t, err)
+	assert.Equal(t,
+		"9c1185a5c5e9fc54612808977ee8f548b2258d31",
+		hex.EncodeToString(out),
+	)
+
+	buf := &bytes.Buffer{}
+	assert.NoError(t, op.encode(newSerializationContext(buf)))
+	assert.Equal(t, []byte{0x03}, buf.Bytes())
+}
+
+func TestMsgKeccak256(t *testing.T) {
+	out, err := msgKeccak256([]byte{})
+	assert.NoError(t, err)
+	// NIST SHA3-256 of the empty string is a7ffc6f8bf1ed766...
+	assert.Equal(t,
+		"c5d2460186f7233c927e7db2dcc703c0"+
+			"e500b653ca82273b7bfad8045d85a470",
+		hex.EncodeToString(out),
+	)
+
+	out, err = msgKeccak256([]byte("abc"))
+	assert.NoError(t, err)
+	assert.Equal(t,
+		"4e03657aea45a94fc7d47ba826c8d667"+
+			"c0d1e6e33a64a036ec44f58fa12d6c45",
+		hex.EncodeToString(out),
+	)
+
+	op, err := parseCryptOp(newDeserializationContextFromBytes([]byte{0x67}))
+	assert.NoError(t, err)
+	assert.Equal(t, "KECCAK256", op.String())
+	assert.Equal(t, 32, op.digestLength)
+}
+
+// encodeBinaryOpArg returns the encoded argument following a binary op tag.
+func encodeBinaryOpArg(arg []byte) []byte {
+	buf := &bytes.Buffer{}
+	ctx := newSerializationContext(buf)
+	if err := ctx.WriteVarBytes(arg); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+func TestBinaryOpArgLength(t *testing.T) {
+	for _, proto := range []*binaryOp{opAppend, opPrepend} {
+		atLimit := bytes.Repeat([]byte{0x01}, maxBinaryArgLength)
+		op, err := parseOp(newDeserializationContextFromBytes(
+			encodeBinaryOpArg(atLimit),
+		), proto.tag)
+		assert.NoError(t, err)
+		assert.Equal(t, atLimit, op.(*binaryOp).argument)
+
+		_, err = parseOp(newDeserializationContextFromBytes(
+			encodeBinaryOpArg(append(atLimit, 0x01)),
+		), proto.tag)
+		if assert.Error(t, err) {
+			assert.True(t, errors.Is(err, ErrPayloadTooLarge), err)
+		}
+
+		// the argument fits, but the result would be too large
+		res, err := op.Execute([]byte{})
+		assert.NoError(t, err)
+		assert.Equal(t, maxResultLength, len(res))
+		_, err = op.Execute([]byte{0x00})
+		assert.Error(t, err)
+	}
+}
+
+func TestExecuteExportedOps(t *testing.T) {
+	digest, err := OpSHA256.Execute([]byte("hello"))
+	assert.NoError(t, err)
+	res, err := OpAppend([]byte{0xaa}).Execute(digest)
+	assert.NoError(t, err)
+	assert.Equal(t, append(digest, 0xaa), res)
+
+	res, err = OpPrepend([]byte{0xbb}).Execute([]byte{0x01})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0xbb, 0x01}, res)
+	assert.Equal(t, "APPEND aa", OpAppend([]byte{0xaa}).String())
+}
+
+func TestComputeResult(t *testing.T) {
+	message := []byte{0x01, 0x02}
+	res, err := ComputeResult(message, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, message, res)
+
+	res, err = ComputeResult(message, []Operation{
+		OpAppend([]byte{0x03}), OpPrepend([]byte{0x00}), OpReverse,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x03, 0x02, 0x01, 0x00}, res)
+	assert.Equal(t, []byte{0x01, 0x02}, message)
+
+	res, err = ComputeResult(message, []Operation{OpSHA256, OpSHA256})
+	assert.NoError(t, err)
+	first := sha256.Sum256(message)
+	second := sha256.Sum256(first[:])
+	assert.Equal(t, second[:], res)
+
+	_, err = ComputeResult(
+		bytes.Repeat([]byte{0x01}, maxResultLength),
+		[]Operation{OpAppend([]byte{0x01})},
+	)
+	assert.Error(t, err)
+}
+
+func TestOpSHA256d(t *testing.T) {
+	res, err := ComputeResult([]byte("hello"), OpSHA256d())
+	require.NoError(t, err)
+	assert.Equal(t, mustDecodeHex(
+		"9595c9df90075148eb06860365df33584b75bff782a510c6cd4883a419833d50",
+	), res)
+
+	// the hash of the genesis block header, shown reversed by explorers
+	header := mustDecodeHex(
+		"0100000000000000000000000000000000000000000000000000000000000000" +
+			"000000003ba3edfd7a7b12b27ac72c3e67768f617fc81bc3888a51323a9fb8aa" +
+			"4b1e5e4a29ab5f49ffff001d1dac2b7c",
+	)
+	res, err = ComputeResult(header, OpSHA256d())
+	require.NoError(t, err)
+	assert.Equal(t,
+		"000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f",
+		ReverseHex(res),
+	)
+}
+
+func BenchmarkComputeResult(b *testing.B) {
+	// a path as deep as the ones leading to bitcoin attestations
+	var path []Operation
+	for i := 0; i < 50; i++ {
+		arg := bytes.Repeat([]byte{byte(i)}, 32)
+		path = append(path, OpAppend(arg), OpSHA256)
+	}
+	message := make([]byte, 32)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := ComputeResult(message, path); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestEmptyBinaryArgument(t *testing.T) {
+	message := []byte{0x01, 0x02}
+	for _, op := range []Operation{OpAppend(nil), OpPrepend([]byte{})} {
+		res, err := op.Execute(message)
+		require.NoError(t, err)
+		assert.Equal(t, message, res)
+	}
+
+	ts := &Timestamp{Message: message}
+	leaf := addOp(t, addOp(t, addOp(t, ts, OpAppend(nil)), OpPrepend(nil)),
+		OpSHA256,
+	)
+	leaf.Attestations = []Attestation{NewBitcoinAttestation(1)}
+	data, err := ts.SerializeToBytes()
+	require.NoError(t, err)
+	// the empty arguments are encoded with a zero length
+	assert.Equal(t, []byte{0xf0, 0x00, 0xf1, 0x00, 0x08}, data[:5])
+
+	parsed, err := NewTimestampFromReader(bytes.NewReader(data), message)
+	require.NoError(t, err)
+	assert.True(t, ts.Equal(parsed))
+	again, err := parsed.SerializeToBytes()
+	require.NoError(t, err)
+	assert.Equal(t, data, again)
+
+	expected := sha256.Sum256(message)
+	parsed.WalkAttestations(func(path []Operation, a Attestation) {
+		assert.Len(t, path, 3)
+		res, err := ComputeResult(message, path)
+		require.NoError(t, err)
+		assert.Equal(t, expected[:], res)
+	})
+}
+
+func withRegisteredOps(f func()) {
+	opCodesMu.Lock()
+	saved := append([]Operation{}, opCodes...)
+	opCodesMu.Unlock()
+	defer func() {
+		opCodesMu.Lock()
+		opCodes = saved
+		opCodesMu.Unlock()
+	}()
+	f()
+}
+
+func TestRegisterHashOp(t *testing.T) {
+	digest := sha512.Sum512([]byte("custom"))
+	bitcoin := append([]byte{attestationMarker}, bitcoinAttestationTag...)
+	bitcoin = append(bitcoin, 0x01, 0x01)
+	// a file hashed with the custom op, followed by one custom op
+	data := append([]byte{}, fileHeaderMagic...)
+	data = append(data, fileMajorVersion, 0x42)
+	data = append(data, digest[:]...)
+	data = append(data, 0x42)
+	data = append(data, bitcoin...)
+
+	_, err := NewDetachedTimestampFromReader(bytes.NewReader(data))
+	assert.True(t, errors.Is(err, ErrUnexpectedMarker))
+
+	withRegisteredOps(func() {
+		op, err := RegisterHashOp(0x42, "SHA512", sha512.New)
+		require.NoError(t, err)
+		_, err = RegisterHashOp(0x42, "SHA512", sha512.New)
+		assert.True(t, errors.Is(err, ErrDuplicateOpTag))
+		_, err = RegisterHashOp(OpSHA256.tag, "SHA256", sha256.New)
+		assert.True(t, errors.Is(err, ErrDuplicateOpTag))
+		_, err = RegisterHashOp(forkMarker, "SHA512", sha512.New)
+		assert.Error(t, err)
+
+		dts, err := NewDetachedTimestampFromReader(bytes.NewReader(data))
+		require.NoError(t, err)
+		assert.Equal(t, "SHA512", dts.HashOp.String())
+		want := sha512.Sum512(digest[:])
+		ts := dts.Timestamp
+		ts.WalkAttestations(func(path []Operation, _ Attestation) {
+			res, err := ComputeResult(ts.Message, path)
+			require.NoError(t, err)
+			assert.Equal(t, want[:], res)
+		})
+		var buf bytes.Buffer
+		require.NoError(t, dts.WriteToStream(&buf))
+		assert.Equal(t, data, buf.Bytes())
+
+		res, err := op.ExecuteReader(bytes.NewReader([]byte("custom")))
+		require.NoError(t, err)
+		assert.Equal(t, digest[:], res)
+	})
+
+	// the registration is undone
+	_, err = NewDetachedTimestampFromReader(bytes.NewReader(data))
+	assert.Error(t, err)
+}
@@ -0,0 +1,82 @@
+package opentimestamps
+
+// ParseOptions controls the limits enforced while parsing untrusted
+// timestamp data. Zero values are replaced by the corresponding value of
+// DefaultParseOptions.
+type ParseOptions struct {
+	// MaxPayloadSize is the maximum size of an attestation payload,
+	// inclusive. It is 8192 bytes by default.
+	MaxPayloadSize int
+	// MaxURILength is the maximum length of a pending attestation uri.
+	MaxURILength int
+	// RequireCanonicalVarUint rejects var-uints that are not minimally
+	// encoded, e.g. padded with a trailing zero byte.
+	RequireCanonicalVarUint bool
+	// Strict enables all format checks, for untrusted input that should be
+	// well-formed, e.g. proofs created by this package:
+	//   - var-uints must be minimally encoded, as with
+	//     RequireCanonicalVarUint
+	//   - pending attestation uris must be valid UTF-8 with a scheme in
+	//     PendingAttestationURISchemes
+	//   - attestation payloads must not have trailing bytes,
+	//     ErrTrailingBytes
+	// Lenient parsing, the default, accepts quirky real-world proofs that
+	// fail these checks: attestations with trailing payload bytes are kept
+	// unparsed as UnknownAttestation and written back unchanged, uris are
+	// kept as they are and only checked when a calendar is contacted.
+	// Empty append and prepend arguments are valid in both modes.
+	// The size, depth and read limits below are enforced in both modes,
+	// they bound the resources a parse uses.
+	Strict bool
+	// MaxReadBytes is the total number of bytes a parse may consume.
+	MaxReadBytes int64
+	// MaxOpDepth is the maximum nesting depth of operations in a timestamp.
+	MaxOpDepth int
+	// MaxBitcoinHeight is the highest block height accepted in a bitcoin
+	// attestation. Use math.MaxUint64 to accept any height.
+	MaxBitcoinHeight uint64
+}
+
+// DefaultParseOptions are used by the parse functions that do not take
+// options.
+var DefaultParseOptions = ParseOptions{
+	MaxPayloadSize: attestationMaxPayloadSize,
+	MaxURILength:   pendingAttestationMaxUriLength,
+	MaxReadBytes:   defaultMaxReadBytes,
+	MaxOpDepth:     defaultMaxOpDepth,
+	// at about 52000 blocks a year this lasts well over a century
+	MaxBitcoinHeight: 10000000,
+}
+
+// default budget for a single parse, generous compared to real proofs which
+// are a few kilobytes at most
+const defaultMaxReadBytes = 4 << 20
+
+// real proofs are a few dozen operations deep
+const defaultMaxOpDepth = 1000
+
+// withDefaults returns a copy of o with unset fields taken from
+// DefaultParseOptions.
+func (o ParseOptions) withDefaults() ParseOptions {
+	if o.MaxPayloadSize == 0 {
+		o.MaxPayloadSize = DefaultParseOptions.MaxPayloadSize
+	}
+	if o.MaxURILength == 0 {
+		o.MaxURILength = DefaultParseOptions.MaxURILength
+	}
+	if o.MaxReadBytes == 0 {
+		o.MaxReadBytes = DefaultParseOptions.MaxReadBytes
+	}
+	if o.MaxOpDepth == 0 {
+		o.MaxOpDepth = DefaultParseOptions.MaxOpDepth
+	}
+	if o.MaxBitcoinHeight == 0 {
+		o.MaxBitcoinHeight = DefaultParseOptions.MaxBitcoinHeight
+	}
+	return o
+}
+
+// canonicalVarUint reports whether var-uints must be minimally encoded.
+func (o ParseOptions) canonicalVarUint() bool {
+	return o.Strict || o.RequireCanonicalVarUint
+}
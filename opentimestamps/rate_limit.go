@@ -0,0 +1,78 @@
+package opentimestamps
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// A RateLimiter paces the requests of calendar clients. Wait blocks until the
+// next request may be sent or ctx is done. *rate.Limiter of
+// golang.org/x/time/rate implements it.
+type RateLimiter interface {
+	Wait(ctx context.Context) error
+}
+
+// intervalLimiter lets one request through per interval.
+type intervalLimiter struct {
+	interval time.Duration
+	mu       sync.Mutex
+	// next is the earliest time of the next request
+	next time.Time
+}
+
+// NewIntervalLimiter returns a RateLimiter that spaces requests at least
+// interval apart. It is safe for concurrent use.
+func NewIntervalLimiter(interval time.Duration) RateLimiter {
+	return &intervalLimiter{interval: interval}
+}
+
+func (l *intervalLimiter) Wait(ctx context.Context) error {
+	l.mu.Lock()
+	now := time.Now()
+	at := l.next
+	if at.Before(now) {
+		at = now
+	}
+	if deadline, ok := ctx.Deadline(); ok && deadline.Before(at) {
+		l.mu.Unlock()
+		return context.DeadlineExceeded
+	}
+	// the slot is reserved before waiting, so concurrent callers queue up
+	l.next = at.Add(l.interval)
+	l.mu.Unlock()
+
+	delay := time.Until(at)
+	if delay <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// PerCalendarLimiter returns a function for CalendarOptions.CalendarLimiter
+// that gives every calendar its own limiter created by newLimiter. Clients
+// for the same url share the limiter, so a calendar is paced across all of
+// them.
+func PerCalendarLimiter(
+	newLimiter func() RateLimiter,
+) func(baseURL string) RateLimiter {
+	var mu sync.Mutex
+	limiters := make(map[string]RateLimiter)
+	return func(baseURL string) RateLimiter {
+		mu.Lock()
+		defer mu.Unlock()
+		l, ok := limiters[baseURL]
+		if !ok {
+			l = newLimiter()
+			limiters[baseURL] = l
+		}
+		return l
+	}
+}
@@ -1,40 +1,80 @@
 package opentimestamps
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/hex"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"mime"
 	"net/http"
 	"net/http/httputil"
 	"strings"
-
-	"github.com/sirupsen/logrus"
+	"time"
 )
 
-const userAgent = "go-opentimestamps"
+// Version is the version of this library, it is sent in the default
+// User-Agent header.
+const Version = "0.1.0"
+
+const defaultUserAgent = "go-opentimestamps/" + Version
+
+// the content type of timestamps returned by calendar servers
+const contentTypeTimestamp = "application/vnd.opentimestamps.v1"
+
+// number of body bytes included in ErrUnexpectedContentType errors
+const contentTypeErrorBodySize = 128
 
 const dumpResponse = false
 
 type RemoteCalendar struct {
 	baseURL string
 	client  *http.Client
-	log     *logrus.Logger
+	log     Logger
+	opts    CalendarOptions
+	// limiters are waited for before every request
+	limiters []RateLimiter
 }
 
 func NewRemoteCalendar(baseURL string) (*RemoteCalendar, error) {
+	return NewRemoteCalendarWithOptions(baseURL, DefaultCalendarOptions)
+}
+
+// NewRemoteCalendarWithOptions is like NewRemoteCalendar but uses opts to
+// configure the client.
+func NewRemoteCalendarWithOptions(
+	baseURL string, opts CalendarOptions,
+) (*RemoteCalendar, error) {
+	opts = opts.withDefaults()
 	// FIXME remove this
 	if baseURL == "localhost" {
 		baseURL = "http://localhost:14788"
 	}
-	// TODO validate url
+	// pending attestations parsed leniently may have any uri
+	if err := validatePendingURI(baseURL); err != nil {
+		return nil, err
+	}
 	if !strings.HasSuffix(baseURL, "/") {
 		baseURL += "/"
 	}
+	var limiters []RateLimiter
+	if opts.CalendarLimiter != nil {
+		if l := opts.CalendarLimiter(baseURL); l != nil {
+			limiters = append(limiters, l)
+		}
+	}
+	if opts.Limiter != nil {
+		limiters = append(limiters, opts.Limiter)
+	}
 	return &RemoteCalendar{
 		baseURL,
-		http.DefaultClient,
-		logrus.New(),
+		opts.HTTPClient,
+		opts.Logger,
+		opts,
+		limiters,
 	}, nil
 }
 
@@ -44,6 +84,12 @@ func checkStatusOK(resp *http.Response) error {
 	if resp.StatusCode == http.StatusOK {
 		return nil
 	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		if resp.Body != nil {
+			resp.Body.Close()
+		}
+		return fmt.Errorf("%w: %q", ErrUnauthorized, resp.Status)
+	}
 	errMsg := fmt.Sprintf("unexpected response: %q", resp.Status)
 	if resp.Body == nil {
 		return fmt.Errorf("%s (body=nil)", errMsg)
@@ -57,16 +103,78 @@ func checkStatusOK(resp *http.Response) error {
 	}
 }
 
+// readTimestampBody checks that resp carries a timestamp and returns a reader
+// for its body. Responses with an unexpected content type or an HTML body,
+// e.g. the error page of a proxy, are rejected with ErrUnexpectedContentType.
+func readTimestampBody(resp *http.Response) (io.Reader, error) {
+	body := bufio.NewReader(resp.Body)
+	head, _ := body.Peek(512)
+	contentType := resp.Header.Get("Content-Type")
+	valid := !strings.HasPrefix(http.DetectContentType(head), "text/html")
+	if contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		valid = valid && err == nil && (mediaType == contentTypeTimestamp ||
+			mediaType == "application/octet-stream")
+	}
+	if !valid {
+		if len(head) > contentTypeErrorBodySize {
+			head = head[:contentTypeErrorBodySize]
+		}
+		return nil, fmt.Errorf(
+			"%w %q (body=%q)", ErrUnexpectedContentType, contentType, head,
+		)
+	}
+	return body, nil
+}
+
+// gzipBody decompresses a response body and closes the underlying body.
+type gzipBody struct {
+	*gzip.Reader
+	body io.ReadCloser
+}
+
+func (g gzipBody) Close() error {
+	g.Reader.Close()
+	return g.body.Close()
+}
+
+// decodeBody replaces the body of a gzip encoded response with its
+// decompressed content. The transport only does this if it added the
+// Accept-Encoding header itself.
+func decodeBody(resp *http.Response) error {
+	if !strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		return nil
+	}
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		resp.Body.Close()
+		return fmt.Errorf("decompressing response: %w", err)
+	}
+	resp.Body = gzipBody{zr, resp.Body}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return nil
+}
+
 func (c *RemoteCalendar) do(r *http.Request) (*http.Response, error) {
-	r.Header.Add("Accept", "application/vnd.opentimestamps.v1")
-	r.Header.Add("User-Agent", userAgent)
+	if r.Header.Get("Accept") == "" {
+		r.Header.Set("Accept", contentTypeTimestamp)
+	}
+	r.Header.Set("Accept-Encoding", "gzip")
+	r.Header.Set("User-Agent", c.opts.UserAgent)
+	c.opts.authorize(r)
 	c.log.Debugf("> %s %s", r.Method, r.URL)
 	resp, err := c.client.Do(r)
 	if err != nil {
-		c.log.Errorf("> %s %s error: %v", r.Method, r.URL, err)
+		c.log.Warnf("> %s %s error: %v", r.Method, r.URL, err)
 		return resp, err
 	}
 	c.log.Debugf("< %s %s - %v", r.Method, r.URL, resp.Status)
+	if err := decodeBody(resp); err != nil {
+		return nil, err
+	}
 	if dumpResponse {
 		bytes, err := httputil.DumpResponse(resp, true)
 		if err == nil {
@@ -76,65 +184,355 @@ func (c *RemoteCalendar) do(r *http.Request) (*http.Response, error) {
 	return resp, err
 }
 
+// isRetryable reports whether a request that failed with err or resp might
+// succeed if sent again.
+func isRetryable(ctx context.Context, resp *http.Response, err error) bool {
+	if err != nil {
+		// network errors, unless the caller gave up
+		return ctx.Err() == nil
+	}
+	return resp.StatusCode == http.StatusTooManyRequests ||
+		resp.StatusCode >= 500
+}
+
+// doWithRetry sends r until it succeeds, fails permanently or
+// opts.MaxAttempts is reached. Every attempt waits for the rate limiters
+// first. It stops waiting when the request context is done.
+func (c *RemoteCalendar) doWithRetry(r *http.Request) (*http.Response, error) {
+	ctx := r.Context()
+	for attempt := 1; ; attempt++ {
+		for _, l := range c.limiters {
+			if err := l.Wait(ctx); err != nil {
+				return nil, err
+			}
+		}
+		resp, err := c.do(r)
+		if attempt >= c.opts.MaxAttempts || !isRetryable(ctx, resp, err) {
+			return resp, err
+		}
+		if resp != nil && resp.Body != nil {
+			resp.Body.Close()
+		}
+		delay := c.opts.retryDelay(attempt)
+		if err != nil {
+			c.log.Infof(
+				"calendar %s failed: %v, retrying in %v",
+				c.baseURL, err, delay,
+			)
+		} else {
+			c.log.Infof(
+				"calendar %s returned %d, retrying in %v",
+				c.baseURL, resp.StatusCode, delay,
+			)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+		if r.GetBody != nil {
+			body, err := r.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			r.Body = body
+		}
+	}
+}
+
 func (c *RemoteCalendar) url(path string) string {
 	return c.baseURL + path
 }
 
-func (c *RemoteCalendar) Submit(digest []byte) (*Timestamp, error) {
+// Submit posts digest to the calendar and returns the pending timestamp the
+// calendar responds with. digest must not be empty.
+func (c *RemoteCalendar) Submit(
+	ctx context.Context, digest []byte,
+) (*Timestamp, error) {
+	if len(digest) == 0 {
+		return nil, fmt.Errorf("empty digest")
+	}
 	body := bytes.NewBuffer(digest)
-	req, err := http.NewRequest("POST", c.url("digest"), body)
+	req, err := http.NewRequestWithContext(
+		ctx, "POST", c.url("digest"), body,
+	)
 	if err != nil {
 		return nil, err
 	}
-	resp, err := c.do(req)
+	resp, err := c.doWithRetry(req)
 	if err != nil {
 		return nil, err
 	}
+	if err := checkStatusOK(resp); err != nil {
+		return nil, err
+	}
 	if resp.Body != nil {
 		defer resp.Body.Close()
 	}
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("expected 200, got %v", resp.Status)
+	respBody, err := readTimestampBody(resp)
+	if err != nil {
+		return nil, err
 	}
-	return NewTimestampFromReader(resp.Body, digest)
+	return NewTimestampFromReader(respBody, digest)
 }
 
-func (c *RemoteCalendar) GetTimestamp(commitment []byte) (*Timestamp, error) {
+// DefaultUpgradeDelay is how long after a submission a calendar is expected
+// to have its commitment confirmed in a bitcoin block. Calendars aggregate
+// submissions before committing to a transaction, which then has to be mined.
+const DefaultUpgradeDelay = 2 * time.Hour
+
+// A Submission records a digest submitted to a calendar, so the pending
+// timestamp can be upgraded once the calendar is likely to have a complete
+// one.
+type Submission struct {
+	// Timestamp is the pending timestamp returned by the calendar.
+	Timestamp *Timestamp
+	// Commitment is the message of the pending attestation, which is what
+	// the calendar is asked for when upgrading.
+	Commitment []byte
+	// CalendarURI is the uri of the pending attestation.
+	CalendarURI string
+	// SubmittedAt is the time the digest was submitted.
+	SubmittedAt time.Time
+}
+
+// ReadyToUpgrade reports whether DefaultUpgradeDelay has passed since the
+// submission at now. It is false if the calendar didn't return a pending
+// attestation.
+func (s *Submission) ReadyToUpgrade(now time.Time) bool {
+	if s.Commitment == nil {
+		return false
+	}
+	return !now.Before(s.SubmittedAt.Add(DefaultUpgradeDelay))
+}
+
+// SubmitRecord is like Submit but returns a Submission recording when and
+// where the pending timestamp has to be upgraded.
+func (c *RemoteCalendar) SubmitRecord(
+	ctx context.Context, digest []byte,
+) (*Submission, error) {
+	submittedAt := time.Now()
+	ts, err := c.Submit(ctx, digest)
+	if err != nil {
+		return nil, err
+	}
+	sub := &Submission{Timestamp: ts, SubmittedAt: submittedAt}
+	if pending := PendingTimestamps(ts); len(pending) > 0 {
+		sub.Commitment = copyBytes(pending[0].Timestamp.Message)
+		sub.CalendarURI = pending[0].PendingAttestation.URI()
+	}
+	return sub, nil
+}
+
+// GetTimestamp fetches the timestamp for commitment, usually the message of a
+// pending attestation. ErrCommitmentNotFound is returned if the calendar
+// doesn't have a timestamp for it yet and the request should be retried
+// later.
+func (c *RemoteCalendar) GetTimestamp(
+	ctx context.Context, commitment []byte,
+) (*Timestamp, error) {
 	url := c.url("timestamp/" + hex.EncodeToString(commitment))
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
-	resp, err := c.do(req)
+	resp, err := c.doWithRetry(req)
 	if err != nil {
 		return nil, err
 	}
+	if resp.StatusCode == http.StatusNotFound {
+		if resp.Body != nil {
+			resp.Body.Close()
+		}
+		return nil, fmt.Errorf("%w: %x", ErrCommitmentNotFound, commitment)
+	}
 	if err := checkStatusOK(resp); err != nil {
 		return nil, err
 	}
 	if resp.Body != nil {
 		defer resp.Body.Close()
 	}
-	return NewTimestampFromReader(resp.Body, commitment)
+	respBody, err := readTimestampBody(resp)
+	if err != nil {
+		return nil, err
+	}
+	return NewTimestampFromReader(respBody, commitment)
+}
+
+// CalendarInfo is the metadata a calendar publishes about itself.
+type CalendarInfo struct {
+	// Tip is the most recent commitment of the calendar, the message its
+	// next bitcoin transaction will commit to. Clients can compare it across
+	// requests to check they are talking to the calendar they expect.
+	Tip []byte
+}
+
+// Info fetches the metadata of the calendar from its tip endpoint, which
+// returns the binary tip digest. ErrCalendarInfoUnsupported is returned if
+// the calendar doesn't implement the endpoint or has no tip yet. Calendars
+// have no endpoint for a public key or capabilities, so Info only reports
+// the tip.
+func (c *RemoteCalendar) Info(ctx context.Context) (*CalendarInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.url("tip"), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/octet-stream")
+	resp, err := c.doWithRetry(req)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusNotFound, http.StatusMethodNotAllowed,
+		http.StatusNotImplemented:
+		if resp.Body != nil {
+			resp.Body.Close()
+		}
+		return nil, fmt.Errorf(
+			"%w: %s returned %q",
+			ErrCalendarInfoUnsupported, c.baseURL, resp.Status,
+		)
+	}
+	if err := checkStatusOK(resp); err != nil {
+		return nil, err
+	}
+	if resp.Body != nil {
+		defer resp.Body.Close()
+	}
+	// calendars without the endpoint may serve their web page instead
+	body, err := readTimestampBody(resp)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"%w: %s: %v", ErrCalendarInfoUnsupported, c.baseURL, err,
+		)
+	}
+	tip, err := ioutil.ReadAll(io.LimitReader(body, maxResultLength+1))
+	if err != nil {
+		return nil, fmt.Errorf("calendar tip: %w", err)
+	}
+	if len(tip) == 0 {
+		return nil, fmt.Errorf(
+			"%w: %s has no tip yet", ErrCalendarInfoUnsupported, c.baseURL,
+		)
+	}
+	if len(tip) > maxResultLength {
+		return nil, fmt.Errorf("calendar tip: invalid length %d", len(tip))
+	}
+	return &CalendarInfo{Tip: tip}, nil
+}
+
+// DefaultCalendars are the public calendars SubmitToCalendars submits to if
+// no urls are passed, the pool used by the reference client. They are network
+// endpoints the library contacts, replace them for tests or private
+// deployments.
+var DefaultCalendars = []string{
+	"https://a.pool.opentimestamps.org",
+	"https://b.pool.opentimestamps.org",
+	"https://a.pool.eternitywall.com",
+	"https://ots.btc.catallaxy.com",
+}
+
+// SubmitToCalendars submits digest to all calendars in urls concurrently and
+// merges the responses into a single timestamp. It returns as soon as
+// minResponses calendars responded successfully. Failing calendars are
+// tolerated as long as that minimum is reached before ctx is done. If urls is
+// empty, DefaultCalendars are used.
+func SubmitToCalendars(
+	ctx context.Context, digest []byte, urls []string, minResponses int,
+) (*Timestamp, error) {
+	return SubmitToCalendarsWithOptions(
+		ctx, digest, urls, minResponses, DefaultCalendarOptions,
+	)
+}
+
+// SubmitToCalendarsWithOptions is like SubmitToCalendars but configures the
+// calendar clients with opts. Failing calendars are reported to opts.Logger.
+func SubmitToCalendarsWithOptions(
+	ctx context.Context, digest []byte, urls []string, minResponses int,
+	opts CalendarOptions,
+) (*Timestamp, error) {
+	opts = opts.withDefaults()
+	if len(urls) == 0 {
+		urls = DefaultCalendars
+	}
+	if minResponses < 1 || minResponses > len(urls) {
+		return nil, fmt.Errorf(
+			"invalid minResponses %d for %d calendars",
+			minResponses, len(urls),
+		)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		url string
+		ts  *Timestamp
+		err error
+	}
+	results := make(chan result, len(urls))
+	for _, url := range urls {
+		go func(url string) {
+			cal, err := NewRemoteCalendarWithOptions(url, opts)
+			if err != nil {
+				results <- result{url, nil, err}
+				return
+			}
+			ts, err := cal.Submit(ctx, digest)
+			results <- result{url, ts, err}
+		}(url)
+	}
+
+	merged := &Timestamp{Message: digest}
+	var errs []string
+	successes := 0
+	for range urls {
+		var res result
+		select {
+		case res = <-results:
+		case <-ctx.Done():
+			return nil, fmt.Errorf(
+				"got %d of %d required calendar responses: %w",
+				successes, minResponses, ctx.Err(),
+			)
+		}
+		if res.err != nil {
+			opts.Logger.Warnf(
+				"submitting to %s failed: %v", res.url, res.err,
+			)
+			errs = append(errs, fmt.Sprintf("%s: %v", res.url, res.err))
+			continue
+		}
+		if err := merged.Merge(res.ts); err != nil {
+			return nil, err
+		}
+		successes++
+		if successes >= minResponses {
+			return merged, nil
+		}
+	}
+	return nil, fmt.Errorf(
+		"got %d of %d required calendar responses (%s)",
+		successes, minResponses, strings.Join(errs, ", "),
+	)
 }
 
 type PendingTimestamp struct {
 	Timestamp          *Timestamp
-	PendingAttestation *pendingAttestation
+	PendingAttestation *PendingAttestation
 }
 
-func (p PendingTimestamp) Upgrade() (*Timestamp, error) {
-	cal, err := NewRemoteCalendar(p.PendingAttestation.uri)
+func (p PendingTimestamp) Upgrade(ctx context.Context) (*Timestamp, error) {
+	cal, err := NewRemoteCalendar(p.PendingAttestation.URI())
 	if err != nil {
 		return nil, err
 	}
-	return cal.GetTimestamp(p.Timestamp.Message)
+	return cal.GetTimestamp(ctx, p.Timestamp.Message)
 }
 
 func PendingTimestamps(ts *Timestamp) (res []PendingTimestamp) {
 	ts.Walk(func(ts *Timestamp) {
 		for _, att := range ts.Attestations {
-			p, ok := att.(*pendingAttestation)
+			p, ok := att.(*PendingAttestation)
 			if !ok {
 				continue
 			}
@@ -1,9 +1,20 @@
 package opentimestamps
 
 import (
+	"compress/gzip"
+	"context"
 	"crypto/sha256"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -16,12 +27,14 @@ const calendarServerEnvvar = "GOTS_TEST_CALENDAR_SERVER"
 const bitcoinRegtestEnvvar = "GOTS_TEST_BITCOIN_REGTEST_SERVER"
 
 func newTestCalendar(url string) *RemoteCalendar {
-	logrus.SetLevel(logrus.DebugLevel)
-	cal, err := NewRemoteCalendar(url)
+	log := logrus.New()
+	log.SetLevel(logrus.DebugLevel)
+	cal, err := NewRemoteCalendarWithOptions(
+		url, CalendarOptions{BaseDelay: time.Millisecond, Logger: log},
+	)
 	if err != nil {
 		panic("could not create test calendar")
 	}
-	cal.log.Level = logrus.DebugLevel
 	return cal
 }
 
@@ -39,7 +52,7 @@ func TestRemoteCalendarExample(t *testing.T) {
 	pts := PendingTimestamps(dts.Timestamp)
 	assert.Equal(t, 2, len(pts))
 	for _, pt := range pts {
-		ts, err := pt.Upgrade()
+		ts, err := pt.Upgrade(context.Background())
 		assert.NoError(t, err)
 		fmt.Print(ts.Dump())
 	}
@@ -51,7 +64,9 @@ func TestRemoteCalendarRoundTrip(t *testing.T) {
 		t.Skipf("%q not set, skipping test", calendarServerEnvvar)
 	}
 	cal := newTestCalendar(calendarServer)
-	ts, err := cal.Submit(newTestDigest("Hello, World!"))
+	ts, err := cal.Submit(
+		context.Background(), newTestDigest("Hello, World!"),
+	)
 	require.NoError(t, err)
 	require.NotNil(t, ts)
 
@@ -62,8 +77,619 @@ func TestRemoteCalendarRoundTrip(t *testing.T) {
 	time.Sleep(2 * time.Second)
 
 	for _, pts := range PendingTimestamps(ts) {
-		ts, err := pts.Upgrade()
+		ts, err := pts.Upgrade(context.Background())
 		assert.NoError(t, err)
 		_ = ts
 	}
 }
+
+func TestRemoteCalendarSubmit(t *testing.T) {
+	digest := newTestDigest("submit")
+	pending := &Timestamp{Message: digest}
+	addOp(t, pending, OpAppend([]byte{0x01})).Attestations = []Attestation{
+		NewPendingAttestation("https://alice.example.com"),
+	}
+	response, err := pending.SerializeToBytes()
+	require.NoError(t, err)
+
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "POST", r.Method)
+			assert.Equal(t, "/digest", r.URL.Path)
+			assert.Equal(t, contentTypeTimestamp, r.Header.Get("Accept"))
+			body, err := ioutil.ReadAll(r.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, digest, body)
+			w.Write(response)
+		},
+	))
+	defer server.Close()
+
+	cal := newTestCalendar(server.URL)
+	ts, err := cal.Submit(context.Background(), digest)
+	require.NoError(t, err)
+	assert.True(t, pending.Equal(ts))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = cal.Submit(ctx, digest)
+	assert.True(t, errors.Is(err, context.Canceled), err)
+}
+
+func TestRemoteCalendarContentType(t *testing.T) {
+	digest := newTestDigest("content type")
+	pending := &Timestamp{Message: digest}
+	pending.Attestations = []Attestation{
+		NewPendingAttestation("https://alice.example.com"),
+	}
+	response, err := pending.SerializeToBytes()
+	require.NoError(t, err)
+	errorPage := []byte("<html><body>502 Bad Gateway</body></html>")
+
+	var contentType string
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if contentType != "" {
+				w.Header().Set("Content-Type", contentType)
+			}
+			w.Write(body)
+		},
+	))
+	defer server.Close()
+	cal := newTestCalendar(server.URL)
+
+	for _, ct := range []string{
+		"", contentTypeTimestamp, "application/octet-stream",
+	} {
+		contentType, body = ct, response
+		ts, err := cal.Submit(context.Background(), digest)
+		require.NoError(t, err, ct)
+		assert.True(t, pending.Equal(ts), ct)
+	}
+
+	for _, ct := range []string{"", "text/html; charset=utf-8"} {
+		contentType, body = ct, errorPage
+		_, err = cal.Submit(context.Background(), digest)
+		assert.True(t, errors.Is(err, ErrUnexpectedContentType), err)
+		assert.Contains(t, err.Error(), "502 Bad Gateway")
+	}
+
+	// an error page with the timestamp content type
+	contentType, body = contentTypeTimestamp, errorPage
+	_, err = cal.GetTimestamp(context.Background(), digest)
+	assert.True(t, errors.Is(err, ErrUnexpectedContentType), err)
+
+	contentType, body = "application/json", response
+	_, err = cal.GetTimestamp(context.Background(), digest)
+	assert.True(t, errors.Is(err, ErrUnexpectedContentType), err)
+}
+
+func TestRemoteCalendarGzip(t *testing.T) {
+	commitment := newTestDigest("gzip")
+	complete := &Timestamp{Message: commitment}
+	complete.Attestations = []Attestation{NewBitcoinAttestation(358391)}
+	response, err := complete.SerializeToBytes()
+	require.NoError(t, err)
+
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "gzip", r.Header.Get("Accept-Encoding"))
+			w.Header().Set("Content-Type", contentTypeTimestamp)
+			w.Header().Set("Content-Encoding", "gzip")
+			zw := gzip.NewWriter(w)
+			_, err := zw.Write(response)
+			assert.NoError(t, err)
+			assert.NoError(t, zw.Close())
+		},
+	))
+	defer server.Close()
+
+	cal := newTestCalendar(server.URL)
+	ts, err := cal.GetTimestamp(context.Background(), commitment)
+	require.NoError(t, err)
+	assert.True(t, complete.Equal(ts))
+}
+
+func TestRemoteCalendarSubmitRecord(t *testing.T) {
+	digest := newTestDigest("submit record")
+	pending := &Timestamp{Message: digest}
+	leaf := addOp(t, pending, OpAppend([]byte{0x01}))
+	leaf.Attestations = []Attestation{
+		NewPendingAttestation("https://alice.example.com"),
+	}
+	response, err := pending.SerializeToBytes()
+	require.NoError(t, err)
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write(response)
+		},
+	))
+	defer server.Close()
+
+	before := time.Now()
+	sub, err := newTestCalendar(server.URL).SubmitRecord(
+		context.Background(), digest,
+	)
+	require.NoError(t, err)
+	assert.True(t, pending.Equal(sub.Timestamp))
+	assert.Equal(t, leaf.Message, sub.Commitment)
+	assert.Equal(t, "https://alice.example.com", sub.CalendarURI)
+	assert.False(t, sub.SubmittedAt.Before(before))
+	assert.False(t, sub.SubmittedAt.After(time.Now()))
+}
+
+func TestSubmissionReadyToUpgrade(t *testing.T) {
+	submittedAt := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	sub := &Submission{
+		Commitment:  []byte{0x01},
+		CalendarURI: "https://alice.example.com",
+		SubmittedAt: submittedAt,
+	}
+	ready := submittedAt.Add(DefaultUpgradeDelay)
+	assert.False(t, sub.ReadyToUpgrade(submittedAt))
+	assert.False(t, sub.ReadyToUpgrade(ready.Add(-time.Nanosecond)))
+	assert.True(t, sub.ReadyToUpgrade(ready))
+	assert.True(t, sub.ReadyToUpgrade(ready.Add(time.Hour)))
+
+	// nothing to upgrade
+	sub.Commitment = nil
+	assert.False(t, sub.ReadyToUpgrade(ready))
+}
+
+func TestRemoteCalendarGetTimestamp(t *testing.T) {
+	commitment := newTestDigest("commitment")
+	upgraded := &Timestamp{Message: commitment}
+	addOp(t, upgraded, OpSHA256).Attestations = []Attestation{
+		NewBitcoinAttestation(1),
+	}
+	response, err := upgraded.SerializeToBytes()
+	require.NoError(t, err)
+
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "GET", r.Method)
+			assert.Equal(t,
+				fmt.Sprintf("/timestamp/%x", commitment), r.URL.Path,
+			)
+			w.WriteHeader(status)
+			if status == http.StatusOK {
+				w.Write(response)
+			}
+		},
+	))
+	defer server.Close()
+	cal := newTestCalendar(server.URL)
+
+	ts, err := cal.GetTimestamp(context.Background(), commitment)
+	require.NoError(t, err)
+	assert.True(t, upgraded.Equal(ts))
+
+	status = http.StatusNotFound
+	_, err = cal.GetTimestamp(context.Background(), commitment)
+	assert.True(t, errors.Is(err, ErrCommitmentNotFound), err)
+
+	status = http.StatusInternalServerError
+	_, err = cal.GetTimestamp(context.Background(), commitment)
+	if assert.Error(t, err) {
+		assert.False(t, errors.Is(err, ErrCommitmentNotFound), err)
+	}
+}
+
+func TestRemoteCalendarInfo(t *testing.T) {
+	tip := newTestDigest("tip")
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/tip":
+				// like the reference calendar server
+				w.Header().Set("Content-Type", "application/octet-stream")
+				w.Write(tip)
+			case "/html/tip":
+				w.Header().Set("Content-Type", "text/html")
+				fmt.Fprint(w, "<html>calendar</html>")
+			case "/empty/tip":
+				w.Header().Set("Content-Type", "application/octet-stream")
+			case "/long/tip":
+				w.Header().Set("Content-Type", "application/octet-stream")
+				w.Write(make([]byte, maxResultLength+1))
+			default:
+				http.NotFound(w, r)
+			}
+		},
+	))
+	defer server.Close()
+
+	info, err := newTestCalendar(server.URL).Info(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, tip, info.Tip)
+
+	for _, path := range []string{"/missing", "/html", "/empty"} {
+		cal := newTestCalendar(server.URL + path)
+		_, err := cal.Info(context.Background())
+		assert.True(t, errors.Is(err, ErrCalendarInfoUnsupported), path)
+	}
+
+	cal := newTestCalendar(server.URL + "/long")
+	_, err = cal.Info(context.Background())
+	require.Error(t, err)
+	assert.False(t, errors.Is(err, ErrCalendarInfoUnsupported))
+}
+
+func TestCalendarOptionsJitter(t *testing.T) {
+	assert.Equal(t,
+		DefaultCalendarOptions.Jitter, CalendarOptions{}.withDefaults().Jitter,
+	)
+	opts := CalendarOptions{Jitter: 0.5}.withDefaults()
+	assert.Equal(t, 0.5, opts.Jitter)
+
+	opts = CalendarOptions{
+		BaseDelay: time.Second, Jitter: NoJitter,
+	}.withDefaults()
+	assert.Zero(t, opts.Jitter)
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, time.Second, opts.retryDelay(1))
+		assert.Equal(t, 4*time.Second, opts.retryDelay(3))
+	}
+}
+
+func TestRemoteCalendarRetry(t *testing.T) {
+	digest := newTestDigest("retry")
+	pending := &Timestamp{Message: digest}
+	pending.Attestations = []Attestation{
+		NewPendingAttestation("https://alice.example.com"),
+	}
+	response, err := pending.SerializeToBytes()
+	require.NoError(t, err)
+
+	var attempts int
+	var failures []int
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			attempts++
+			body, err := ioutil.ReadAll(r.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, digest, body)
+			if len(failures) > 0 {
+				w.WriteHeader(failures[0])
+				failures = failures[1:]
+				return
+			}
+			w.Write(response)
+		},
+	))
+	defer server.Close()
+	cal := newTestCalendar(server.URL)
+
+	failures = []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}
+	ts, err := cal.Submit(context.Background(), digest)
+	require.NoError(t, err)
+	assert.True(t, pending.Equal(ts))
+	assert.Equal(t, 3, attempts)
+
+	attempts = 0
+	failures = []int{500, 500, 500}
+	_, err = cal.Submit(context.Background(), digest)
+	assert.Error(t, err)
+	assert.Equal(t, 3, attempts)
+
+	// permanent errors are not retried
+	attempts = 0
+	failures = []int{http.StatusBadRequest}
+	_, err = cal.Submit(context.Background(), digest)
+	assert.Error(t, err)
+	assert.Equal(t, 1, attempts)
+
+	// retries are reported to the logger
+	log := &recordingLogger{}
+	cal, err = NewRemoteCalendarWithOptions(server.URL, CalendarOptions{
+		BaseDelay: time.Millisecond, Logger: log,
+	})
+	require.NoError(t, err)
+	attempts = 0
+	failures = []int{http.StatusServiceUnavailable}
+	_, err = cal.Submit(context.Background(), digest)
+	require.NoError(t, err)
+	retries := log.matching("retrying")
+	require.Len(t, retries, 1)
+	assert.Contains(t, retries[0], "returned 503")
+
+	// cancellation interrupts the delay between attempts
+	cal, err = NewRemoteCalendarWithOptions(
+		server.URL, CalendarOptions{BaseDelay: time.Hour},
+	)
+	require.NoError(t, err)
+	failures = []int{500}
+	ctx, cancel := context.WithTimeout(
+		context.Background(), 50*time.Millisecond,
+	)
+	defer cancel()
+	start := time.Now()
+	_, err = cal.Submit(ctx, digest)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), err)
+	assert.True(t, time.Since(start) < time.Minute)
+}
+
+// newStubCalendar returns a server that responds to every submission with a
+// pending attestation for uri, or with status if it is not 200.
+func newStubCalendar(t *testing.T, uri string, status int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if status != http.StatusOK {
+				w.WriteHeader(status)
+				return
+			}
+			digest, err := ioutil.ReadAll(r.Body)
+			assert.NoError(t, err)
+			ts := &Timestamp{Message: digest}
+			ts.Attestations = []Attestation{NewPendingAttestation(uri)}
+			assert.NoError(t, ts.Serialize(w))
+		},
+	))
+}
+
+func TestSubmitToCalendars(t *testing.T) {
+	alice := newStubCalendar(t, "https://alice.example.com", http.StatusOK)
+	defer alice.Close()
+	bob := newStubCalendar(t, "https://bob.example.com", http.StatusOK)
+	defer bob.Close()
+	broken := newStubCalendar(t, "", http.StatusBadRequest)
+	defer broken.Close()
+
+	digest := newTestDigest("aggregate")
+	urls := []string{alice.URL, broken.URL, bob.URL}
+	ts, err := SubmitToCalendars(context.Background(), digest, urls, 2)
+	require.NoError(t, err)
+	assert.True(t, (&Timestamp{
+		Message: digest,
+		Attestations: []Attestation{
+			NewPendingAttestation("https://alice.example.com"),
+			NewPendingAttestation("https://bob.example.com"),
+		},
+	}).Equal(ts))
+
+	_, err = SubmitToCalendars(context.Background(), digest, urls, 3)
+	assert.Error(t, err)
+	_, err = SubmitToCalendars(context.Background(), digest, urls, 4)
+	assert.Error(t, err)
+
+	log := &recordingLogger{}
+	_, err = SubmitToCalendarsWithOptions(
+		context.Background(), digest, urls, 3,
+		CalendarOptions{Logger: log},
+	)
+	assert.Error(t, err)
+	assert.Len(t, log.matching("submitting to "+broken.URL+" failed"), 1)
+
+	defaults := DefaultCalendars
+	defer func() { DefaultCalendars = defaults }()
+	DefaultCalendars = []string{alice.URL, bob.URL}
+	ts, err = SubmitToCalendars(context.Background(), digest, nil, 2)
+	require.NoError(t, err)
+	assert.Len(t, ts.Attestations, 2)
+}
+
+// serveSOCKS5 accepts CONNECT requests without authentication on l and
+// forwards them, counting the connections in proxied.
+func serveSOCKS5(t *testing.T, l net.Listener, proxied *int32) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			buf := make([]byte, 262)
+			// greeting: version, number of methods, methods
+			if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+				return
+			}
+			if _, err := io.ReadFull(conn, buf[:buf[1]]); err != nil {
+				return
+			}
+			conn.Write([]byte{0x05, 0x00})
+			// request: version, command, reserved, address type
+			if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+				return
+			}
+			var host string
+			switch buf[3] {
+			case 0x01:
+				io.ReadFull(conn, buf[:4])
+				host = net.IP(buf[:4]).String()
+			case 0x03:
+				io.ReadFull(conn, buf[:1])
+				n := int(buf[0])
+				io.ReadFull(conn, buf[:n])
+				host = string(buf[:n])
+			default:
+				t.Errorf("unexpected address type %d", buf[3])
+				return
+			}
+			if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+				return
+			}
+			port := int(buf[0])<<8 | int(buf[1])
+			target, err := net.Dial(
+				"tcp", net.JoinHostPort(host, strconv.Itoa(port)),
+			)
+			if err != nil {
+				conn.Write([]byte{0x05, 0x01, 0, 0x01, 0, 0, 0, 0, 0, 0})
+				return
+			}
+			defer target.Close()
+			atomic.AddInt32(proxied, 1)
+			conn.Write([]byte{0x05, 0x00, 0, 0x01, 0, 0, 0, 0, 0, 0})
+			go io.Copy(target, conn)
+			io.Copy(conn, target)
+		}(conn)
+	}
+}
+
+func TestRemoteCalendarSOCKS5(t *testing.T) {
+	server := newStubCalendar(t, "https://alice.example.com", http.StatusOK)
+	defer server.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	var proxied int32
+	go serveSOCKS5(t, l, &proxied)
+
+	cal, err := NewRemoteCalendarWithOptions(server.URL, CalendarOptions{
+		HTTPClient: NewSOCKS5Client(l.Addr().String()),
+	})
+	require.NoError(t, err)
+	ts, err := cal.Submit(context.Background(), newTestDigest("tor"))
+	require.NoError(t, err)
+	assert.Len(t, ts.Attestations, 1)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&proxied))
+}
+
+func TestRemoteCalendarCancel(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			<-release
+		},
+	))
+	defer server.Close()
+	defer close(release)
+	cal := newTestCalendar(server.URL)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := cal.GetTimestamp(ctx, newTestDigest("hang"))
+	assert.True(t, errors.Is(err, context.Canceled), err)
+	assert.True(t, time.Since(start) < 10*time.Second)
+
+	pending := PendingTimestamp{
+		Timestamp:          &Timestamp{Message: newTestDigest("hang")},
+		PendingAttestation: NewPendingAttestation(server.URL),
+	}
+	ctx, cancel = context.WithTimeout(
+		context.Background(), 50*time.Millisecond,
+	)
+	defer cancel()
+	_, err = pending.Upgrade(ctx)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), err)
+}
+
+func TestRemoteCalendarUserAgent(t *testing.T) {
+	var userAgents []string
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			userAgents = append(userAgents, r.Header.Get("User-Agent"))
+			w.WriteHeader(http.StatusBadRequest)
+		},
+	))
+	defer server.Close()
+
+	cal := newTestCalendar(server.URL)
+	cal.Submit(context.Background(), newTestDigest("ua"))
+	cal, err := NewRemoteCalendarWithOptions(
+		server.URL, CalendarOptions{UserAgent: "stamper/1.0"},
+	)
+	require.NoError(t, err)
+	cal.GetTimestamp(context.Background(), newTestDigest("ua"))
+
+	assert.Equal(t, []string{defaultUserAgent, "stamper/1.0"}, userAgents)
+	assert.Equal(t, "go-opentimestamps/"+Version, defaultUserAgent)
+}
+
+func TestRemoteCalendarAuth(t *testing.T) {
+	var attempts int
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			attempts++
+			user, pass, ok := r.BasicAuth()
+			if r.Header.Get("Authorization") == "Bearer secret" ||
+				ok && user == "alice" && pass == "secret" {
+				digest, err := ioutil.ReadAll(r.Body)
+				assert.NoError(t, err)
+				ts := &Timestamp{Message: digest}
+				ts.Attestations = []Attestation{NewBitcoinAttestation(1)}
+				assert.NoError(t, ts.Serialize(w))
+				return
+			}
+			w.WriteHeader(http.StatusUnauthorized)
+		},
+	))
+	defer server.Close()
+	digest := newTestDigest("auth")
+
+	for _, opts := range []CalendarOptions{
+		{BearerToken: "secret"},
+		{Username: "alice", Password: "secret"},
+	} {
+		cal, err := NewRemoteCalendarWithOptions(server.URL, opts)
+		require.NoError(t, err)
+		_, err = cal.Submit(context.Background(), digest)
+		assert.NoError(t, err)
+	}
+
+	attempts = 0
+	cal, err := NewRemoteCalendarWithOptions(
+		server.URL, CalendarOptions{BearerToken: "wrong"},
+	)
+	require.NoError(t, err)
+	_, err = cal.Submit(context.Background(), digest)
+	assert.True(t, errors.Is(err, ErrUnauthorized), err)
+	assert.NotContains(t, err.Error(), "wrong")
+	assert.Equal(t, 1, attempts)
+}
+
+func TestRemoteCalendarRateLimit(t *testing.T) {
+	const interval = 20 * time.Millisecond
+	var mu sync.Mutex
+	var times []time.Time
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			times = append(times, time.Now())
+			mu.Unlock()
+			http.NotFound(w, r)
+		},
+	))
+	defer server.Close()
+
+	// two clients for the same calendar share its limiter
+	opts := CalendarOptions{
+		CalendarLimiter: PerCalendarLimiter(func() RateLimiter {
+			return NewIntervalLimiter(interval)
+		}),
+	}
+	var cals []*RemoteCalendar
+	for i := 0; i < 2; i++ {
+		cal, err := NewRemoteCalendarWithOptions(server.URL, opts)
+		require.NoError(t, err)
+		cals = append(cals, cal)
+	}
+	for i := 0; i < 4; i++ {
+		cals[i%2].GetTimestamp(context.Background(), newTestDigest("rate"))
+	}
+	require.Len(t, times, 4)
+	for i := 1; i < len(times); i++ {
+		// allow for the timer resolution
+		assert.True(t, times[i].Sub(times[i-1]) > interval*3/4)
+	}
+
+	// waiting for the limiter respects the context
+	cal, err := NewRemoteCalendarWithOptions(server.URL, CalendarOptions{
+		Limiter: NewIntervalLimiter(time.Hour),
+	})
+	require.NoError(t, err)
+	_, err = cal.GetTimestamp(context.Background(), newTestDigest("rate"))
+	assert.True(t, errors.Is(err, ErrCommitmentNotFound), err)
+	ctx, cancel := context.WithTimeout(
+		context.Background(), 50*time.Millisecond,
+	)
+	defer cancel()
+	start := time.Now()
+	_, err = cal.GetTimestamp(ctx, newTestDigest("rate"))
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), err)
+	assert.True(t, time.Since(start) < 10*time.Second)
+	assert.Len(t, times, 5)
+}
@@ -5,37 +5,55 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"math"
+	"sync"
 )
 
-// serializationContext helps encoding values in the ots format
-type serializationContext struct {
+// A SerializationContext writes values in the ots format. It is passed to
+// the Encode methods of attestations.
+type SerializationContext struct {
 	w io.Writer
+	// canonical sorts attestations and operations before writing them
+	canonical bool
 }
 
-// newSerializationContext returns a serializationContext for a writer
-func newSerializationContext(w io.Writer) *serializationContext {
-	return &serializationContext{w}
+// newSerializationContext returns a SerializationContext for a writer
+func newSerializationContext(w io.Writer) *SerializationContext {
+	return &SerializationContext{w: w}
 }
 
-// writeBytes writes the raw bytes to the underlying writer
-func (s serializationContext) writeBytes(b []byte) error {
-	// number of bytes can be ignored
-	// if it is equal len(b) then err is nil
-	_, err := s.w.Write(b)
+// WriteBytes writes b as it is.
+func (s SerializationContext) WriteBytes(b []byte) error {
+	n, err := s.w.Write(b)
 	if err != nil {
 		return err
 	}
+	if n < len(b) {
+		return io.ErrShortWrite
+	}
 	return nil
 }
 
+// countingWriter counts the bytes written to w, for io.WriterTo.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
 // writeByte writes a single byte
-func (s serializationContext) writeByte(b byte) error {
-	return s.writeBytes([]byte{b})
+func (s SerializationContext) writeByte(b byte) error {
+	return s.WriteBytes([]byte{b})
 }
 
 // writeBool encodes and writes a boolean value
-func (s serializationContext) writeBool(b bool) error {
+func (s SerializationContext) writeBool(b bool) error {
 	if b {
 		return s.writeByte(0xff)
 	} else {
@@ -43,10 +61,10 @@ func (s serializationContext) writeBool(b bool) error {
 	}
 }
 
-// writeVarUint encodes and writes writes a variable-length integer
-func (s serializationContext) writeVarUint(v uint64) error {
+// WriteVarUint writes v as a variable-length integer.
+func (s SerializationContext) WriteVarUint(v uint64) error {
 	if v == 0 {
-		s.writeByte(0x00)
+		return s.writeByte(0x00)
 	}
 	for v > 0 {
 		b := byte(v & 0x7f)
@@ -64,58 +82,231 @@ func (s serializationContext) writeVarUint(v uint64) error {
 	return nil
 }
 
-// writeVarBytes encodes and writes a variable-length array
-func (s serializationContext) writeVarBytes(arr []byte) error {
-	if err := s.writeVarUint(uint64(len(arr))); err != nil {
+// WriteVarBytes writes the length of arr as a variable-length integer,
+// followed by arr.
+func (s SerializationContext) WriteVarBytes(arr []byte) error {
+	if err := s.WriteVarUint(uint64(len(arr))); err != nil {
 		return err
 	}
-	return s.writeBytes(arr)
+	return s.WriteBytes(arr)
+}
+
+// A DeserializationContext reads values of the ots format from a stream or a
+// byte slice, enforcing the limits of its ParseOptions. It is passed to the
+// decode functions of operations and attestations, which read their values
+// with ReadBytes, ReadVarUint and ReadVarBytes. The payload of an attestation
+// is decoded from a context holding just the payload, so decoders can use
+// Remaining and Peek to look at the rest of it.
+type DeserializationContext struct {
+	r    *bufio.Reader
+	opts ParseOptions
+	// number of bytes consumed so far, used in error messages
+	offset int64
+	// data is read instead of r if fromBytes is set, see resetBytes
+	data      []byte
+	fromBytes bool
+	// recoverable errors are collected here instead of failing the parse if
+	// collect is set, see ParseLenientCollect
+	collect bool
+	errs    []error
 }
 
-// deserializationContext helps decoding values from the ots format
-type deserializationContext struct {
-	r io.Reader
+// An offsetError records where in the stream a read failed.
+type offsetError struct {
+	what   string
+	offset int64
+	err    error
 }
 
-// safety boundary for readBytes
+func (e *offsetError) Error() string {
+	return fmt.Sprintf("read %s at offset %d: %v", e.what, e.offset, e.err)
+}
+
+func (e *offsetError) Unwrap() error {
+	return e.err
+}
+
+// wrapErr annotates err with the offset of the value being read. Errors of
+// nested reads are re-annotated, so the outermost value is reported.
+func wrapErr(what string, offset int64, err error) error {
+	if err == nil {
+		return nil
+	}
+	if oe, ok := err.(*offsetError); ok {
+		err = oe.err
+	}
+	return &offsetError{what, offset, err}
+}
+
+// safety boundary for ReadBytes
 // allocation limit for arrays
 const maxReadSize = (1 << 12)
 
-func (d deserializationContext) dump() string {
-	arr, _ := d.r.(*bufio.Reader).Peek(512)
+func (d *DeserializationContext) dump() string {
+	if d.fromBytes {
+		n := len(d.data)
+		if n > 512 {
+			n = 512
+		}
+		return fmt.Sprintf("% x", d.data[:n])
+	}
+	arr, _ := d.r.Peek(512)
 	return fmt.Sprintf("% x", arr)
 }
 
-// readBytes reads n bytes.
-func (d deserializationContext) readBytes(n int) ([]byte, error) {
+// ReadBytes reads n bytes, at most 4096. If d reads from a byte slice, e.g.
+// an attestation payload, the result shares its memory and must be copied
+// before it is modified or kept beyond the decoded value.
+func (d *DeserializationContext) ReadBytes(n int) ([]byte, error) {
 	if n > maxReadSize {
-		return nil, fmt.Errorf("over maxReadSize: %d", maxReadSize)
+		return nil, wrapErr("bytes", d.offset, fmt.Errorf(
+			"%w: over maxReadSize %d", ErrPayloadTooLarge, maxReadSize,
+		))
+	}
+	b, err := d.read(n)
+	if err != nil {
+		// no short slices, the error tells how much was missing
+		return nil, wrapErr("bytes", d.offset-int64(len(b)), err)
+	}
+	return b, nil
+}
+
+// read reads exactly n bytes without checking maxReadSize. Callers must
+// bound n themselves.
+func (d *DeserializationContext) read(n int) ([]byte, error) {
+	if err := d.checkReadLimit(int64(n)); err != nil {
+		return nil, err
+	}
+	if d.fromBytes {
+		return d.readData(n)
+	}
+	if n > readChunkSize {
+		return d.readChunked(n)
 	}
 	b := make([]byte, n)
-	m, err := d.r.Read(b)
+	m, err := io.ReadFull(d.r, b)
+	d.offset += int64(m)
+	if err == io.ErrUnexpectedEOF {
+		return b[:m], fmt.Errorf(
+			"%w: expected %d bytes, got %d", ErrUnexpectedEOF, n, m,
+		)
+	}
+	if err == io.EOF {
+		return b[:m], eofError{}
+	}
 	if err != nil {
-		return b, err
+		return b[:m], err
 	}
-	if n != m {
-		return b, fmt.Errorf("expected %d bytes, got %d", n, m)
+	return b, nil
+}
+
+// readChunkSize is the size up to which read allocates the requested length
+// before reading. Larger values are read by readChunked.
+const readChunkSize = 512
+
+// readChunked reads n bytes from the stream in chunks of readChunkSize into a
+// buffer that grows with the data actually read, so a length prefix larger
+// than the remaining input doesn't allocate the declared size. The errors
+// match the ones of read.
+func (d *DeserializationContext) readChunked(n int) ([]byte, error) {
+	b := make([]byte, 0, readChunkSize)
+	for len(b) < n {
+		chunk := n - len(b)
+		if chunk > readChunkSize {
+			chunk = readChunkSize
+		}
+		if cap(b)-len(b) < chunk {
+			grown := make([]byte, len(b), 2*cap(b))
+			copy(grown, b)
+			b = grown
+		}
+		m, err := io.ReadFull(d.r, b[len(b):len(b)+chunk])
+		b = b[:len(b)+m]
+		d.offset += int64(m)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			if len(b) == 0 {
+				return nil, eofError{}
+			}
+			return b, fmt.Errorf(
+				"%w: expected %d bytes, got %d", ErrUnexpectedEOF, n, len(b),
+			)
+		}
+		if err != nil {
+			return b, err
+		}
 	}
-	return b[:], nil
+	return b, nil
+}
+
+// readData returns the next n bytes of d.data without copying them. The
+// errors match the ones of read.
+func (d *DeserializationContext) readData(n int) ([]byte, error) {
+	m := n
+	if m > len(d.data) {
+		m = len(d.data)
+	}
+	// limit the capacity so appending to b can't overwrite d.data
+	b := d.data[:m:m]
+	d.data = d.data[m:]
+	d.offset += int64(m)
+	if m == n {
+		return b, nil
+	}
+	if m == 0 {
+		return b, eofError{}
+	}
+	return b, fmt.Errorf(
+		"%w: expected %d bytes, got %d", ErrUnexpectedEOF, n, m,
+	)
+}
+
+// checkReadLimit returns an error if reading n more bytes would exceed the
+// read budget.
+func (d *DeserializationContext) checkReadLimit(n int64) error {
+	if d.offset+n > d.opts.MaxReadBytes {
+		return fmt.Errorf(
+			"%w: reading %d bytes at offset %d, limit %d",
+			ErrReadLimitExceeded, n, d.offset, d.opts.MaxReadBytes,
+		)
+	}
+	return nil
+}
+
+// readRemaining reads all bytes until the end of the reader.
+func (d *DeserializationContext) readRemaining() ([]byte, error) {
+	offset := d.offset
+	// read one byte more than allowed to detect exceeding the limit
+	limit := d.opts.MaxReadBytes - d.offset + 1
+	var b []byte
+	var err error
+	if d.fromBytes {
+		b, d.data = d.data[:len(d.data):len(d.data)], nil
+	} else {
+		b, err = ioutil.ReadAll(io.LimitReader(d.r, limit))
+	}
+	if err == nil {
+		err = d.checkReadLimit(int64(len(b)))
+	}
+	d.offset += int64(len(b))
+	return b, wrapErr("remaining bytes", offset, err)
 }
 
 // readByte reads a single byte.
-func (d deserializationContext) readByte() (byte, error) {
-	arr, err := d.readBytes(1)
+func (d *DeserializationContext) readByte() (byte, error) {
+	offset := d.offset
+	arr, err := d.ReadBytes(1)
 	if err != nil {
-		return 0, err
+		return 0, wrapErr("byte", offset, err)
 	}
 	return arr[0], nil
 }
 
 // readBool reads a boolean.
-func (d deserializationContext) readBool() (bool, error) {
-	arr, err := d.readBytes(1)
+func (d *DeserializationContext) readBool() (bool, error) {
+	offset := d.offset
+	arr, err := d.ReadBytes(1)
 	if err != nil {
-		return false, err
+		return false, wrapErr("bool", offset, err)
 	}
 	switch v := arr[0]; v {
 	case 0x00:
@@ -123,84 +314,220 @@ func (d deserializationContext) readBool() (bool, error) {
 	case 0xff:
 		return true, nil
 	default:
-		return false, fmt.Errorf("unexpected value %x", v)
+		return false, wrapErr(
+			"bool", offset, fmt.Errorf("unexpected value %x", v),
+		)
 	}
 }
 
-// readVarUint reads a variable-length uint64.
-func (d deserializationContext) readVarUint() (uint64, error) {
+// ReadVarUint reads a variable-length uint64. If ParseOptions.Strict or
+// RequireCanonicalVarUint is set, encodings padded with zeros are rejected.
+func (d *DeserializationContext) ReadVarUint() (uint64, error) {
 	// NOTE
 	// the original python implementation has no uint64 limit, but I
 	// don't think we'll ever need more that that.
+	offset := d.offset
 	val := uint64(0)
 	shift := uint(0)
 	for {
 		b, err := d.readByte()
 		if err != nil {
-			return 0, err
+			return 0, wrapErr("varuint", offset, err)
 		}
-		shifted := uint64(b&0x7f) << shift
-		// ghetto overflow check
-		if (shifted >> shift) != uint64(b&0x7f) {
-			return 0, fmt.Errorf("uint64 overflow")
+		payload := uint64(b & 0x7f)
+		// only the lowest bit of the tenth byte still fits
+		if shift > 63 || (shift == 63 && payload > 1) {
+			return 0, wrapErr(
+				"varuint", offset,
+				fmt.Errorf("varuint overflows uint64"),
+			)
 		}
-		val |= shifted
+		val |= payload << shift
 		if b&0x80 == 0 {
+			// a zero final byte only adds padding
+			if d.opts.canonicalVarUint() && shift > 0 && payload == 0 {
+				return 0, wrapErr(
+					"varuint", offset,
+					fmt.Errorf("non-canonical varuint encoding"),
+				)
+			}
 			return val, nil
 		}
 		shift += 7
 	}
 }
 
-// readVarBytes reads variable-length number of bytes.
-func (d deserializationContext) readVarBytes(minLen, maxLen int) ([]byte, error) {
-	v, err := d.readVarUint()
+// ReadVarBytes reads a length prefix and that many bytes. Both bounds are
+// inclusive, a length of exactly minLen or maxLen is accepted. Lengths above
+// maxLen are ErrPayloadTooLarge. The result shares memory like the one of
+// ReadBytes.
+func (d *DeserializationContext) ReadVarBytes(
+	minLen, maxLen int,
+) ([]byte, error) {
+	offset := d.offset
+	v, err := d.ReadVarUint()
 	if err != nil {
-		return nil, err
+		return nil, wrapErr("varbytes", offset, err)
 	}
 	if v > math.MaxInt32 {
-		return nil, fmt.Errorf("int overflow")
+		return nil, wrapErr(
+			"varbytes", offset,
+			fmt.Errorf("%w: int overflow", ErrPayloadTooLarge),
+		)
 	}
 	vint := int(v)
 	if maxLen < vint || vint < minLen {
-		return nil, fmt.Errorf(
-			"varbytes length %d outside range (%d, %d)",
+		err := fmt.Errorf(
+			"varbytes length %d outside range [%d, %d]",
 			vint, minLen, maxLen,
 		)
+		if vint > maxLen {
+			err = fmt.Errorf("%w: %v", ErrPayloadTooLarge, err)
+		}
+		return nil, wrapErr("varbytes", offset, err)
 	}
 
-	return d.readBytes(vint)
+	// the length is bounded by maxLen, which may exceed maxReadSize
+	b, err := d.read(vint)
+	if err != nil {
+		return nil, wrapErr("varbytes", offset, err)
+	}
+	return b, nil
 }
 
 // assertMagic removes reads the expected bytes from the stream. Returns an
 // error if the bytes are unexpected.
-func (d deserializationContext) assertMagic(expected []byte) error {
-	arr, err := d.readBytes(len(expected))
+func (d *DeserializationContext) assertMagic(expected []byte) error {
+	offset := d.offset
+	arr, err := d.ReadBytes(len(expected))
 	if err != nil {
-		return err
+		return wrapErr("magic", offset, err)
 	}
 	if !bytes.Equal(expected, arr) {
-		return fmt.Errorf(
-			"magic bytes mismatch, expected % x got % x",
-			expected, arr,
-		)
+		return wrapErr("magic", offset, fmt.Errorf(
+			"%w: expected % x got % x", ErrUnknownMagic, expected, arr,
+		))
 	}
 	return nil
 }
 
-// assertEOF reads a byte and returns true if the end of the reader is reached.
-// Careful: the read operation is a side-effect.
-func (d deserializationContext) assertEOF() bool {
-	// Unfortunately we can't always do a zero-byte read here, since some
-	// reader implementations fail to return EOF. This means assertEOF
-	_, err := d.readByte()
+// assertEOF returns true if the end of the reader is reached.
+func (d *DeserializationContext) assertEOF() bool {
+	if n := d.Remaining(); n >= 0 {
+		return n == 0
+	}
+	// Peek does not consume the byte nor count against the read limit.
+	_, err := d.r.Peek(1)
 	return err == io.EOF
 }
 
-// newDeserializationContext returns a deserializationContext for a reader
-func newDeserializationContext(r io.Reader) *deserializationContext {
+// Remaining returns the number of bytes left to read, or -1 if d reads from
+// a stream of unknown length.
+func (d *DeserializationContext) Remaining() int {
+	if d.fromBytes {
+		return len(d.data)
+	}
+	return -1
+}
+
+// Peek returns the next n bytes without consuming them, at most maxReadSize.
+// If fewer bytes are left they are returned with an error wrapping
+// ErrUnexpectedEOF. The returned slice is only valid until the next read and
+// must not be modified. Peeking does not count against the read limit.
+func (d *DeserializationContext) Peek(n int) ([]byte, error) {
+	if n < 0 || n > maxReadSize {
+		return nil, wrapErr("peek", d.offset, fmt.Errorf(
+			"%w: %d bytes, allowed 0 to %d",
+			ErrPayloadTooLarge, n, maxReadSize,
+		))
+	}
+	var b []byte
+	var err error
+	if d.fromBytes {
+		m := n
+		if m > len(d.data) {
+			m = len(d.data)
+		}
+		b = d.data[:m:m]
+	} else {
+		b, err = d.r.Peek(n)
+	}
+	if len(b) < n && (err == nil || err == io.EOF) {
+		err = fmt.Errorf(
+			"%w: expected %d bytes, got %d", ErrUnexpectedEOF, n, len(b),
+		)
+	}
+	return b, wrapErr("peek", d.offset, err)
+}
+
+// recover records err and reports whether parsing can continue without the
+// value that failed.
+func (d *DeserializationContext) recover(err error) bool {
+	if !d.collect {
+		return false
+	}
+	d.errs = append(d.errs, err)
+	return true
+}
+
+// contextPool holds DeserializationContexts to reuse their bufio buffers
+// across parses.
+var contextPool = sync.Pool{
+	New: func() interface{} {
+		return &DeserializationContext{r: bufio.NewReader(nil)}
+	},
+}
+
+// Reset makes d read from r with opts, discarding all previous state.
+func (d *DeserializationContext) Reset(r io.Reader, opts ParseOptions) {
+	d.data, d.fromBytes = nil, false
+	d.r.Reset(r)
+	d.opts = opts.withDefaults()
+	d.offset = 0
+	d.collect, d.errs = false, nil
+}
+
+// resetBytes makes d read from b with opts. Reads return sub-slices of b
+// instead of copies, so b must not be modified while the values read from d
+// are in use.
+func (d *DeserializationContext) resetBytes(b []byte, opts ParseOptions) {
+	d.Reset(nil, opts)
+	d.data, d.fromBytes = b, true
+}
+
+// getDeserializationContext returns a context from the pool reading from r.
+// It must be returned with putDeserializationContext when parsing is done.
+func getDeserializationContext(
+	r io.Reader, opts ParseOptions,
+) *DeserializationContext {
+	d := contextPool.Get().(*DeserializationContext)
+	d.Reset(r, opts)
+	return d
+}
+
+// putDeserializationContext returns d to the pool. It drops the references
+// to the previous reader so no data is retained.
+func putDeserializationContext(d *DeserializationContext) {
+	d.Reset(nil, ParseOptions{})
+	contextPool.Put(d)
+}
+
+// newDeserializationContext returns a DeserializationContext for a reader
+func newDeserializationContext(r io.Reader) *DeserializationContext {
 	// TODO
 	// bufio is used here to allow debugging via d.dump()
 	// once this code here is robust enough we can just pass r
-	return &deserializationContext{bufio.NewReader(r)}
+	return NewDeserializationContext(r, DefaultParseOptions)
+}
+
+// NewDeserializationContext returns a DeserializationContext reading from r
+// that enforces the limits in opts, e.g. to test the decoder of a registered
+// attestation type with ParseAttestation.
+func NewDeserializationContext(
+	r io.Reader, opts ParseOptions,
+) *DeserializationContext {
+	return &DeserializationContext{
+		r:    bufio.NewReader(r),
+		opts: opts.withDefaults(),
+	}
 }
@@ -1,14 +1,19 @@
 package opentimestamps
 
 import (
+	"bufio"
 	"bytes"
+	"errors"
+	"io"
 	"math"
+	"runtime"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
-func newDeserializationContextFromBytes(in []byte) *deserializationContext {
+func newDeserializationContextFromBytes(in []byte) *DeserializationContext {
 	return newDeserializationContext(bytes.NewBuffer(in))
 }
 
@@ -17,26 +22,26 @@ func TestReadWrite(t *testing.T) {
 	buf := &bytes.Buffer{}
 	s := newSerializationContext(buf)
 
-	assert.NoError(t, s.writeBytes([]byte{0x00, 0x01}))
+	assert.NoError(t, s.WriteBytes([]byte{0x00, 0x01}))
 	assert.NoError(t, s.writeByte(0x02))
 	assert.NoError(t, s.writeBool(true))
 	assert.NoError(t, s.writeBool(false))
 	assert.NoError(t, s.writeByte(0x03))
-	assert.NoError(t, s.writeVarUint(1))
-	assert.NoError(t, s.writeBytes([]byte{0x81, 0x00}))
-	assert.NoError(t, s.writeBytes([]byte{0x81, 0x01}))
-	assert.NoError(t, s.writeVarUint(0x100))
-	assert.NoError(t, s.writeVarUint(uint64(math.MaxUint32)+1))
-	assert.NoError(t, s.writeVarUint(math.MaxUint64))
-	assert.NoError(t, s.writeBytes([]byte{
+	assert.NoError(t, s.WriteVarUint(1))
+	assert.NoError(t, s.WriteBytes([]byte{0x81, 0x00}))
+	assert.NoError(t, s.WriteBytes([]byte{0x81, 0x01}))
+	assert.NoError(t, s.WriteVarUint(0x100))
+	assert.NoError(t, s.WriteVarUint(uint64(math.MaxUint32)+1))
+	assert.NoError(t, s.WriteVarUint(math.MaxUint64))
+	assert.NoError(t, s.WriteBytes([]byte{
 		// varunit excess MaxUint64
 		0xff, 0xff, 0xff, 0xff,
 		0xff, 0xff, 0xff, 0xff,
 		0xff, 0xff, 0x01,
 	}))
-	assert.NoError(t, s.writeBytes(magic))
+	assert.NoError(t, s.WriteBytes(magic))
 	assert.NoError(t, s.writeByte(0))
-	assert.NoError(t, s.writeBytes(magic))
+	assert.NoError(t, s.WriteBytes(magic))
 
 	data := buf.Bytes()
 
@@ -77,7 +82,7 @@ func TestReadWrite(t *testing.T) {
 	d := newDeserializationContextFromBytes(data)
 
 	{
-		v, err := d.readBytes(2)
+		v, err := d.ReadBytes(2)
 		assert.NoError(t, err)
 		assert.Equal(t, []byte{0x00, 0x01}, v)
 	}
@@ -101,37 +106,37 @@ func TestReadWrite(t *testing.T) {
 		assert.Error(t, err)
 	}
 	{
-		v, err := d.readVarUint()
+		v, err := d.ReadVarUint()
 		assert.NoError(t, err)
 		assert.Equal(t, uint64(1), v)
 	}
 	{
-		v, err := d.readVarUint()
+		v, err := d.ReadVarUint()
 		assert.NoError(t, err)
 		assert.Equal(t, uint64(1), v)
 	}
 	{
-		v, err := d.readVarUint()
+		v, err := d.ReadVarUint()
 		assert.NoError(t, err)
 		assert.Equal(t, uint64(0x81), v)
 	}
 	{
-		v, err := d.readVarUint()
+		v, err := d.ReadVarUint()
 		assert.NoError(t, err)
 		assert.Equal(t, uint64(0x100), v)
 	}
 	{
-		v, err := d.readVarUint()
+		v, err := d.ReadVarUint()
 		assert.NoError(t, err)
 		assert.Equal(t, uint64(math.MaxUint32)+uint64(1), v)
 	}
 	{
-		v, err := d.readVarUint()
+		v, err := d.ReadVarUint()
 		assert.NoError(t, err)
 		assert.Equal(t, uint64(math.MaxUint64), uint64(v))
 	}
 	{
-		_, err := d.readVarUint()
+		_, err := d.ReadVarUint()
 		assert.Error(t, err)
 		// read leftover 0x02
 		b, err := d.readByte()
@@ -151,3 +156,329 @@ func TestReadWrite(t *testing.T) {
 		assert.True(t, d.assertEOF())
 	}
 }
+
+func TestVarUintRoundTrip(t *testing.T) {
+	for _, v := range []uint64{
+		0, 1, 0x7f, 0x80, 0xff, 0x3fff, 0x4000, 358391,
+		math.MaxUint32, math.MaxUint64 - 1, math.MaxUint64,
+	} {
+		buf := &bytes.Buffer{}
+		assert.NoError(t, newSerializationContext(buf).WriteVarUint(v))
+		d := newDeserializationContextFromBytes(buf.Bytes())
+		res, err := d.ReadVarUint()
+		assert.NoError(t, err)
+		assert.Equal(t, v, res)
+		assert.True(t, d.assertEOF())
+	}
+}
+
+func TestVarBytesRoundTrip(t *testing.T) {
+	for _, n := range []int{0, 1, 0x7f, 0x80, 300, maxReadSize} {
+		arr := bytes.Repeat([]byte{0xab}, n)
+		buf := &bytes.Buffer{}
+		assert.NoError(t, newSerializationContext(buf).WriteVarBytes(arr))
+		d := newDeserializationContextFromBytes(buf.Bytes())
+		res, err := d.ReadVarBytes(0, maxReadSize)
+		assert.NoError(t, err)
+		assert.Equal(t, arr, res)
+		assert.True(t, d.assertEOF())
+	}
+
+	// lengths outside of [minLen, maxLen] are rejected
+	buf := &bytes.Buffer{}
+	s := newSerializationContext(buf)
+	assert.NoError(t, s.WriteVarBytes([]byte("abc")))
+	d := newDeserializationContextFromBytes(buf.Bytes())
+	_, err := d.ReadVarBytes(4, 8)
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, ErrPayloadTooLarge), err)
+	d = newDeserializationContextFromBytes(buf.Bytes())
+	_, err = d.ReadVarBytes(0, 2)
+	assert.True(t, errors.Is(err, ErrPayloadTooLarge), err)
+
+	// the bounds themselves are accepted
+	for _, bounds := range [][2]int{{3, 8}, {0, 3}, {3, 3}} {
+		d = newDeserializationContextFromBytes(buf.Bytes())
+		res, err := d.ReadVarBytes(bounds[0], bounds[1])
+		assert.NoError(t, err, bounds)
+		assert.Equal(t, []byte("abc"), res, bounds)
+	}
+}
+
+func TestBytesRoundTrip(t *testing.T) {
+	buf := &bytes.Buffer{}
+	s := newSerializationContext(buf)
+	assert.NoError(t, s.WriteBytes([]byte("abc")))
+	assert.NoError(t, s.WriteBytes([]byte{}))
+	assert.NoError(t, s.writeBool(true))
+
+	d := newDeserializationContextFromBytes(buf.Bytes())
+	res, err := d.ReadBytes(3)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("abc"), res)
+	b, err := d.readBool()
+	assert.NoError(t, err)
+	assert.True(t, b)
+	// reading past the end fails
+	_, err = d.ReadBytes(1)
+	assert.Error(t, err)
+}
+
+func TestReadVarUintOverflow(t *testing.T) {
+	for _, data := range [][]byte{
+		// ten 0xff bytes
+		bytes.Repeat([]byte{0xff}, 10),
+		// 2^64
+		append(bytes.Repeat([]byte{0x80}, 9), 0x02),
+		// zero payload but more than 64 bits of shift
+		append(bytes.Repeat([]byte{0x80}, 20), 0x00),
+	} {
+		_, err := newDeserializationContextFromBytes(data).ReadVarUint()
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "overflows uint64")
+		}
+	}
+}
+
+func TestReadVarUintCanonical(t *testing.T) {
+	strict := ParseOptions{RequireCanonicalVarUint: true}
+	for _, c := range []struct {
+		data      []byte
+		value     uint64
+		canonical bool
+	}{
+		{[]byte{0x00}, 0, true},
+		{[]byte{0x80, 0x00}, 0, false},
+		{[]byte{0x01}, 1, true},
+		{[]byte{0x81, 0x00}, 1, false},
+		{[]byte{0x81, 0x80, 0x00}, 1, false},
+		{[]byte{0x80, 0x01}, 0x80, true},
+		{[]byte{0x80, 0x81, 0x00}, 0x80, false},
+	} {
+		lenient := newDeserializationContextFromBytes(c.data)
+		v, err := lenient.ReadVarUint()
+		assert.NoError(t, err)
+		assert.Equal(t, c.value, v)
+
+		d := NewDeserializationContext(
+			bytes.NewBuffer(c.data), strict,
+		)
+		v, err = d.ReadVarUint()
+		if c.canonical {
+			assert.NoError(t, err, "% x", c.data)
+			assert.Equal(t, c.value, v)
+		} else {
+			assert.Error(t, err, "% x", c.data)
+		}
+	}
+}
+
+func TestReadErrorOffset(t *testing.T) {
+	d := newDeserializationContextFromBytes([]byte{0x00, 0x01, 0x05, 0xaa})
+	_, err := d.ReadBytes(2)
+	assert.NoError(t, err)
+	_, err = d.ReadVarBytes(0, 10)
+	if assert.Error(t, err) {
+		assert.Equal(t,
+			"read varbytes at offset 2: unexpected EOF: expected 5 bytes, got 1",
+			err.Error(),
+		)
+	}
+
+	d = newDeserializationContextFromBytes([]byte{0x01, 0x02})
+	_, err = d.ReadBytes(1)
+	assert.NoError(t, err)
+	_, err = d.ReadVarUint()
+	assert.NoError(t, err)
+	_, err = d.ReadVarUint()
+	if assert.Error(t, err) {
+		assert.Equal(t, "read varuint at offset 2: EOF", err.Error())
+	}
+}
+
+func TestAttestationErrorOffset(t *testing.T) {
+	// bitcoin attestation with a truncated height inside its payload
+	data := append(
+		[]byte{0xaa, 0xbb},
+		encodeRawAttestation(bitcoinAttestationTag, []byte{0x80})...,
+	)
+	d := newDeserializationContextFromBytes(data)
+	_, err := d.ReadBytes(2)
+	assert.NoError(t, err)
+	_, err = ParseAttestation(d)
+	if assert.Error(t, err) {
+		// 2 prefix bytes, 8 tag bytes, 1 length byte
+		assert.Contains(t, err.Error(), "at offset 11")
+	}
+}
+
+func TestSentinelErrors(t *testing.T) {
+	// truncated in the middle of a value
+	_, err := newDeserializationContextFromBytes([]byte{0x01}).ReadBytes(2)
+	assert.True(t, errors.Is(err, ErrUnexpectedEOF), err)
+
+	// truncated at a value boundary
+	_, err = newDeserializationContextFromBytes([]byte{}).ReadVarUint()
+	assert.True(t, errors.Is(err, ErrUnexpectedEOF), err)
+	assert.True(t, errors.Is(err, io.EOF), err)
+
+	_, err = newDeserializationContextFromBytes(
+		[]byte{0x05, 0, 0, 0, 0, 0},
+	).ReadVarBytes(0, 4)
+	assert.True(t, errors.Is(err, ErrPayloadTooLarge), err)
+
+	// too short is not too large
+	_, err = newDeserializationContextFromBytes(
+		[]byte{0x01, 0},
+	).ReadVarBytes(2, 4)
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, ErrPayloadTooLarge), err)
+
+	err = newDeserializationContextFromBytes(
+		[]byte("magic"),
+	).assertMagic([]byte("MAGIC"))
+	assert.True(t, errors.Is(err, ErrUnknownMagic), err)
+
+	_, err = ParseAttestationWithOptions(newDeserializationContextFromBytes(
+		encodeRawAttestation(bitcoinAttestationTag, []byte{0x01, 0x02}),
+	), ParseOptions{Strict: true})
+	assert.True(t, errors.Is(err, ErrTrailingBytes), err)
+}
+
+func TestReadVarBytesUnderrun(t *testing.T) {
+	// declares 8192 bytes, followed by 10
+	data := append([]byte{0x80, 0x40}, make([]byte, 10)...)
+	att := append(append([]byte{}, bitcoinAttestationTag...), data...)
+
+	fromStream := func(in []byte) *DeserializationContext {
+		return getDeserializationContext(
+			bytes.NewReader(in), DefaultParseOptions,
+		)
+	}
+	fromBytes := func(in []byte) *DeserializationContext {
+		ctx := getDeserializationContext(nil, DefaultParseOptions)
+		ctx.resetBytes(in, DefaultParseOptions)
+		return ctx
+	}
+	for name, newCtx := range map[string]func([]byte) *DeserializationContext{
+		"stream": fromStream, "bytes": fromBytes,
+	} {
+		ctx := newCtx(data)
+		b, err := ctx.ReadVarBytes(0, 8192)
+		assert.True(t, errors.Is(err, ErrUnexpectedEOF), name, err)
+		assert.Nil(t, b, name)
+		putDeserializationContext(ctx)
+
+		ctx = newCtx(att)
+		_, err = ParseAttestation(ctx)
+		assert.True(t, errors.Is(err, ErrUnexpectedEOF), name, err)
+		putDeserializationContext(ctx)
+	}
+
+	// the declared length is not allocated up front. The context is reused
+	// so its bufio buffer isn't counted, the pool may drop it between runs.
+	const runs = 100
+	reused := &DeserializationContext{r: bufio.NewReader(nil)}
+	r := bytes.NewReader(data)
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	for i := 0; i < runs; i++ {
+		r.Reset(data)
+		reused.Reset(r, DefaultParseOptions)
+		_, err := reused.ReadVarBytes(0, 8192)
+		require.Error(t, err)
+	}
+	runtime.ReadMemStats(&after)
+	assert.Less(t, (after.TotalAlloc-before.TotalAlloc)/runs, uint64(8192/4))
+
+	// lengths above readChunkSize are still read completely
+	payload := bytes.Repeat([]byte{0xab}, 5000)
+	var buf bytes.Buffer
+	require.NoError(t, newSerializationContext(&buf).WriteVarBytes(payload))
+	ctx := fromStream(buf.Bytes())
+	defer putDeserializationContext(ctx)
+	b, err := ctx.ReadVarBytes(0, 8192)
+	require.NoError(t, err)
+	assert.Equal(t, payload, b)
+	assert.True(t, ctx.assertEOF())
+}
+
+func TestDeserializationContextReset(t *testing.T) {
+	// not taken from the pool, the test keeps using it after resetting
+	ctx := &DeserializationContext{r: bufio.NewReader(nil)}
+	ctx.Reset(
+		bytes.NewBuffer([]byte{0x01, 0x02, 0x03}),
+		ParseOptions{MaxReadBytes: 10},
+	)
+	b, err := ctx.readByte()
+	require.NoError(t, err)
+	assert.Equal(t, byte(0x01), b)
+
+	// nothing of the previous parse is left
+	ctx.Reset(nil, ParseOptions{})
+	assert.Equal(t, int64(0), ctx.offset)
+	assert.Equal(t, DefaultParseOptions, ctx.opts)
+	assert.Equal(t, 0, ctx.r.Buffered())
+	assert.Nil(t, ctx.data)
+
+	ctx.resetBytes([]byte{0x04}, ParseOptions{})
+	b, err = ctx.readByte()
+	require.NoError(t, err)
+	assert.Equal(t, byte(0x04), b)
+	_, err = ctx.readByte()
+	assert.True(t, errors.Is(err, io.EOF), err)
+}
+
+func TestReadFromBytesNoCopy(t *testing.T) {
+	data := []byte{0x01, 0x02, 0x03}
+	ctx := &DeserializationContext{r: bufio.NewReader(nil)}
+	ctx.resetBytes(data, ParseOptions{})
+
+	b, err := ctx.ReadBytes(2)
+	require.NoError(t, err)
+	assert.True(t, &b[0] == &data[0], "ReadBytes copied the data")
+	// appending must not overwrite the unread byte
+	_ = append(b, 0xff)
+	assert.Equal(t, []byte{0x01, 0x02, 0x03}, data)
+
+	_, err = ctx.ReadBytes(2)
+	assert.True(t, errors.Is(err, ErrUnexpectedEOF), err)
+	assert.True(t, ctx.assertEOF())
+	_, err = ctx.readByte()
+	assert.True(t, errors.Is(err, io.EOF), err)
+}
+
+func TestDeserializationContextPeek(t *testing.T) {
+	data := []byte{0x01, 0x02, 0x03}
+	stream := newDeserializationContextFromBytes(data)
+	slice := &DeserializationContext{r: bufio.NewReader(nil)}
+	slice.resetBytes(data, DefaultParseOptions)
+	assert.Equal(t, -1, stream.Remaining())
+	assert.Equal(t, 3, slice.Remaining())
+
+	for _, ctx := range []*DeserializationContext{stream, slice} {
+		b, err := ctx.Peek(2)
+		require.NoError(t, err)
+		assert.Equal(t, []byte{0x01, 0x02}, b)
+
+		// peeking doesn't consume
+		v, err := ctx.readByte()
+		require.NoError(t, err)
+		assert.Equal(t, byte(0x01), v)
+
+		b, err = ctx.Peek(3)
+		assert.True(t, errors.Is(err, ErrUnexpectedEOF), err)
+		assert.Equal(t, []byte{0x02, 0x03}, b)
+
+		_, err = ctx.Peek(maxReadSize + 1)
+		assert.True(t, errors.Is(err, ErrPayloadTooLarge), err)
+
+		_, err = ctx.ReadBytes(2)
+		require.NoError(t, err)
+		b, err = ctx.Peek(0)
+		assert.NoError(t, err)
+		assert.Empty(t, b)
+		assert.True(t, ctx.assertEOF())
+	}
+	assert.Equal(t, 0, slice.Remaining())
+}
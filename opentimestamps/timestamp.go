@@ -2,26 +2,48 @@ package opentimestamps
 
 import (
 	"bytes"
+	"encoding/base64"
 	"fmt"
 	"io"
+	"os"
+	"reflect"
+	"sort"
 	"strings"
 )
 
+// dumpConfig configures Timestamp.DumpIndent.
 type dumpConfig struct {
+	// showMessage adds the result of each operation to its line
 	showMessage bool
-	showFlat    bool
 }
 
 var defaultDumpConfig dumpConfig = dumpConfig{
-	showMessage: true,
-	showFlat:    false,
+	showMessage: false,
 }
 
+// result returns the annotation of an operation leading to ts.
+func (cfg dumpConfig) result(ts *Timestamp) string {
+	if !cfg.showMessage {
+		return ""
+	}
+	return fmt.Sprintf(" == %x", ts.Message)
+}
+
+// Markers in the serialization of a timestamp. Each node is an attestation,
+// starting with attestationMarker, or an operation, starting with its tag, and
+// all nodes of a timestamp except the last are preceded by forkMarker. There
+// is no end-of-branch marker, a branch ends with the node that has no
+// forkMarker before it.
+const (
+	forkMarker        byte = 0xff
+	attestationMarker byte = 0x00
+)
+
 // A timestampLink with the opCode being the link edge. The reference
 // implementation uses a map, but the implementation is a bit complex. A list
 // should work as well.
 type tsLink struct {
-	opCode    opCode
+	opCode    Operation
 	timestamp *Timestamp
 }
 
@@ -32,6 +54,12 @@ type Timestamp struct {
 	ops          []tsLink
 }
 
+// MessageDigest returns a copy of the message t commits to. For the root of
+// a detached timestamp this is the digest of the file.
+func (t *Timestamp) MessageDigest() []byte {
+	return copyBytes(t.Message)
+}
+
 // Walk calls the passed function f for this timestamp and all
 // downstream timestamps that are chained via operations.
 func (t *Timestamp) Walk(f func(t *Timestamp)) {
@@ -41,25 +69,490 @@ func (t *Timestamp) Walk(f func(t *Timestamp)) {
 	}
 }
 
-func (t *Timestamp) encode(ctx *serializationContext) error {
+// WalkAttestations calls fn for every attestation in t and the timestamps
+// below it. path holds the operations leading from t to the attestation,
+// executing them on t.Message gives the message the attestation commits to.
+func (t *Timestamp) WalkAttestations(
+	fn func(path []Operation, a Attestation),
+) {
+	t.walkAttestations(nil, fn)
+}
+
+func (t *Timestamp) walkAttestations(
+	path []Operation, fn func(path []Operation, a Attestation),
+) {
+	for _, att := range t.Attestations {
+		fn(append([]Operation{}, path...), att)
+	}
+	for _, l := range t.ops {
+		l.timestamp.walkAttestations(append(path, l.opCode), fn)
+	}
+}
+
+// AllAttestations returns the attestations of t and of all timestamps below
+// it, in the order of WalkAttestations. Attestations that are in several
+// branches are returned for each of them.
+func (t *Timestamp) AllAttestations() []Attestation {
+	var atts []Attestation
+	t.WalkAttestations(func(_ []Operation, a Attestation) {
+		atts = append(atts, a)
+	})
+	return atts
+}
+
+// PendingURIs returns the distinct calendar uris of the pending attestations
+// in t, in the order they are found. The result is empty but not nil if t has
+// no pending attestations.
+func (t *Timestamp) PendingURIs() []string {
+	uris := []string{}
+	seen := make(map[string]bool)
+	t.WalkAttestations(func(_ []Operation, a Attestation) {
+		p, ok := a.(*PendingAttestation)
+		if !ok || seen[p.URI()] {
+			return
+		}
+		seen[p.URI()] = true
+		uris = append(uris, p.URI())
+	})
+	return uris
+}
+
+// A Status summarizes the attestations of a timestamp, see Timestamp.Status.
+type Status int
+
+const (
+	// StatusEmpty means the timestamp has no attestations.
+	StatusEmpty Status = iota
+	// StatusUnknownOnly means all attestations are of unknown types.
+	StatusUnknownOnly
+	// StatusPending means the timestamp has pending attestations and needs
+	// to be upgraded.
+	StatusPending
+	// StatusComplete means the timestamp has an attestation of a block in a
+	// bitcoin, litecoin or ethereum chain.
+	StatusComplete
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusEmpty:
+		return "empty"
+	case StatusUnknownOnly:
+		return "unknown only"
+	case StatusPending:
+		return "pending"
+	case StatusComplete:
+		return "complete"
+	default:
+		return fmt.Sprintf("Status(%d)", int(s))
+	}
+}
+
+// Status returns the most advanced state of the attestations in t.
+func (t *Timestamp) Status() Status {
+	status := StatusEmpty
+	t.WalkAttestations(func(_ []Operation, a Attestation) {
+		var s Status
+		switch a.(type) {
+		case *BitcoinAttestation, *LitecoinAttestation,
+			*EthereumAttestation:
+			s = StatusComplete
+		case *PendingAttestation:
+			s = StatusPending
+		default:
+			s = StatusUnknownOnly
+		}
+		if s > status {
+			status = s
+		}
+	})
+	return status
+}
+
+// Equal reports whether t and other have the same message, attestations and
+// operation branches. The order of attestations and sibling branches does not
+// matter.
+func (t *Timestamp) Equal(other *Timestamp) bool {
+	if t == nil || other == nil {
+		return t == other
+	}
+	if !bytes.Equal(t.Message, other.Message) {
+		return false
+	}
+	if len(t.Attestations) != len(other.Attestations) {
+		return false
+	}
+	if len(t.ops) != len(other.ops) {
+		return false
+	}
+	matched := make([]bool, len(other.Attestations))
+	for _, att := range t.Attestations {
+		found := false
+		for i, otherAtt := range other.Attestations {
+			if !matched[i] && att.Equal(otherAtt) {
+				matched[i], found = true, true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	matched = make([]bool, len(other.ops))
+	for _, l := range t.ops {
+		found := false
+		for i, otherLink := range other.ops {
+			if !matched[i] && sameOp(l.opCode, otherLink.opCode) &&
+				l.timestamp.Equal(otherLink.timestamp) {
+				matched[i], found = true, true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Merge adds the attestations and operation branches of other to t. Both
+// timestamps must be for the same message. Attestations and operations that t
+// already has are not duplicated, the branches below equal operations are
+// merged recursively. Branches that are new to t are copied, later changes to
+// t don't affect other.
+func (t *Timestamp) Merge(other *Timestamp) error {
+	if other == nil {
+		return fmt.Errorf("nil timestamp")
+	}
+	if !bytes.Equal(t.Message, other.Message) {
+		return fmt.Errorf(
+			"cannot merge timestamps of different messages %x and %x",
+			t.Message, other.Message,
+		)
+	}
+	t.merge(other)
+	return nil
+}
+
+func (t *Timestamp) merge(other *Timestamp) {
+	for _, att := range other.Attestations {
+		if !t.hasAttestation(att) {
+			t.Attestations = append(t.Attestations, att)
+		}
+	}
+	for _, l := range other.ops {
+		if next := t.findOp(l.opCode); next != nil {
+			next.merge(l.timestamp)
+		} else {
+			t.ops = append(t.ops, tsLink{l.opCode, l.timestamp.Clone()})
+		}
+	}
+}
+
+// Clone returns a deep copy of t. Messages and branches are copied, so the
+// copy can be changed without affecting t. Attestations and operations are
+// not modified in place by this package and are shared.
+func (t *Timestamp) Clone() *Timestamp {
+	c := &Timestamp{Message: copyBytes(t.Message)}
+	if t.Attestations != nil {
+		c.Attestations = append([]Attestation{}, t.Attestations...)
+	}
+	for _, l := range t.ops {
+		c.ops = append(c.ops, tsLink{l.opCode, l.timestamp.Clone()})
+	}
+	return c
+}
+
+// Diff compares the attestations of two timestamps for the same message.
+// onlyA and onlyB are the attestations that only a or only b has, common are
+// those of a that b has as well. Attestations are compared with Equal,
+// regardless of the path leading to them, and each is reported once even if
+// it is in several branches. If onlyB is empty, merging b into a adds no
+// attestations.
+func Diff(a, b *Timestamp) (onlyA, onlyB, common []Attestation, err error) {
+	if !bytes.Equal(a.Message, b.Message) {
+		return nil, nil, nil, fmt.Errorf(
+			"cannot compare timestamps of different messages %x and %x",
+			a.Message, b.Message,
+		)
+	}
+	attsA := distinctAttestations(a.AllAttestations())
+	attsB := distinctAttestations(b.AllAttestations())
+	for _, att := range attsA {
+		if containsAttestation(attsB, att) {
+			common = append(common, att)
+		} else {
+			onlyA = append(onlyA, att)
+		}
+	}
+	for _, att := range attsB {
+		if !containsAttestation(attsA, att) {
+			onlyB = append(onlyB, att)
+		}
+	}
+	return onlyA, onlyB, common, nil
+}
+
+// distinctAttestations returns atts without the attestations that are Equal
+// to an earlier one.
+func distinctAttestations(atts []Attestation) []Attestation {
+	var distinct []Attestation
+	for _, att := range atts {
+		if !containsAttestation(distinct, att) {
+			distinct = append(distinct, att)
+		}
+	}
+	return distinct
+}
+
+func containsAttestation(atts []Attestation, att Attestation) bool {
+	for _, a := range atts {
+		if a.Equal(att) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Timestamp) hasAttestation(att Attestation) bool {
+	return containsAttestation(t.Attestations, att)
+}
+
+// findOp returns the timestamp linked to t via an operation equal to op, or
+// nil if there is none.
+func (t *Timestamp) findOp(op Operation) *Timestamp {
+	for _, l := range t.ops {
+		if sameOp(l.opCode, op) {
+			return l.timestamp
+		}
+	}
+	return nil
+}
+
+// AddAttestationAt adds a to the leaf of t whose message is commitment, e.g.
+// the leaf of a pending attestation once the calendar reports the block it
+// was confirmed in. It fails if t has no such leaf or the leaf already has an
+// attestation equal to a.
+func (t *Timestamp) AddAttestationAt(commitment []byte, a Attestation) error {
+	if a == nil {
+		return fmt.Errorf("nil attestation")
+	}
+	var leaf *Timestamp
+	t.Walk(func(ts *Timestamp) {
+		if leaf == nil && len(ts.ops) == 0 &&
+			bytes.Equal(ts.Message, commitment) {
+			leaf = ts
+		}
+	})
+	if leaf == nil {
+		return fmt.Errorf("no leaf for commitment %x", commitment)
+	}
+	if leaf.hasAttestation(a) {
+		return fmt.Errorf("leaf %x already has %v", commitment, a)
+	}
+	leaf.Attestations = append(leaf.Attestations, a)
+	return nil
+}
+
+// Prune reduces t to the shortest path of operations that leads to an
+// attestation for which keep returns true. All other attestations and
+// branches are removed. For example keeping only bitcoin attestations drops
+// the pending attestations of a timestamp that has already been upgraded.
+// An error is returned and t is left unchanged if no attestation is kept.
+func (t *Timestamp) Prune(keep func(Attestation) bool) error {
+	if t.pathLength(keep) < 0 {
+		return fmt.Errorf("no attestation left after pruning")
+	}
+	t.prune(keep)
+	return nil
+}
+
+// pathLength returns the number of operations on the shortest path to a kept
+// attestation, or -1 if there is none.
+func (t *Timestamp) pathLength(keep func(Attestation) bool) int {
+	for _, att := range t.Attestations {
+		if keep(att) {
+			return 0
+		}
+	}
+	shortest := -1
+	for _, l := range t.ops {
+		n := l.timestamp.pathLength(keep)
+		if n >= 0 && (shortest < 0 || n+1 < shortest) {
+			shortest = n + 1
+		}
+	}
+	return shortest
+}
+
+func (t *Timestamp) prune(keep func(Attestation) bool) {
+	var kept []Attestation
+	for _, att := range t.Attestations {
+		if keep(att) {
+			kept = append(kept, att)
+		}
+	}
+	t.Attestations = kept
+	if len(kept) > 0 {
+		t.ops = nil
+		return
+	}
+	// Prune is only called on timestamps with a path to a kept attestation,
+	// so one of the branches has one.
+	var best tsLink
+	shortest := -1
+	for _, l := range t.ops {
+		n := l.timestamp.pathLength(keep)
+		if n >= 0 && (shortest < 0 || n < shortest) {
+			best, shortest = l, n
+		}
+	}
+	t.ops = []tsLink{best}
+	best.timestamp.prune(keep)
+}
+
+// ExtractPath returns a new timestamp with only the operations from t.Message
+// to a, and a as its single attestation. The result verifies like a does in
+// t. a is looked up by identity first, e.g. as returned by AllAttestations,
+// unless its type can't be compared with ==. Otherwise it is looked up by
+// Equal, in which case the first match is used.
+func (t *Timestamp) ExtractPath(a Attestation) (*Timestamp, error) {
+	if a == nil {
+		return nil, fmt.Errorf("nil attestation")
+	}
+	var path, equalPath []Operation
+	var found, foundEqual bool
+	// == panics for values of types like structs with slices
+	comparable := reflect.TypeOf(a).Comparable()
+	t.WalkAttestations(func(p []Operation, att Attestation) {
+		if !found && comparable && att == a {
+			path, found = p, true
+		} else if !foundEqual && att.Equal(a) {
+			equalPath, foundEqual = p, true
+		}
+	})
+	if !found {
+		if !foundEqual {
+			return nil, fmt.Errorf("attestation %v not in timestamp", a)
+		}
+		path = equalPath
+	}
+	b := NewBuilder(t.Message)
+	for _, op := range path {
+		b.Op(op)
+	}
+	return b.Attest(a).Build()
+}
+
+// dropUnattested removes the branches that don't lead to an attestation, e.g.
+// the ones a failed parse left incomplete. It reports whether t still has an
+// attestation.
+func (t *Timestamp) dropUnattested() bool {
+	var kept []tsLink
+	for _, l := range t.ops {
+		if l.timestamp.dropUnattested() {
+			kept = append(kept, l)
+		}
+	}
+	t.ops = kept
+	return len(t.Attestations) > 0 || len(t.ops) > 0
+}
+
+// ValidateStructure checks that t is well-formed without contacting any
+// backend: the message of t isn't empty, every operation applied to the
+// message of its timestamp gives the message of the next one, hash operations
+// give digests of their size, the operations aren't nested deeper than a parse
+// allows and every branch ends in an attestation. The returned error is an
+// ErrInvalidStructure naming the path to the first problem found.
+func (t *Timestamp) ValidateStructure() error {
+	return t.validateStructure(nil)
+}
+
+func (t *Timestamp) validateStructure(path []Operation) error {
+	fail := func(format string, args ...interface{}) error {
+		at := "root"
+		if len(path) > 0 {
+			names := make([]string, len(path))
+			for i, op := range path {
+				names[i] = op.String()
+			}
+			at = strings.Join(names, " -> ")
+		}
+		return fmt.Errorf(
+			"%w: at %s: %s",
+			ErrInvalidStructure, at, fmt.Sprintf(format, args...),
+		)
+	}
+	if len(path) == 0 && len(t.Message) == 0 {
+		return fail("empty message")
+	}
+	if len(t.Attestations) == 0 && len(t.ops) == 0 {
+		return fail("branch without attestation")
+	}
+	for _, a := range t.Attestations {
+		if a == nil {
+			return fail("nil attestation")
+		}
+	}
+	if len(t.ops) > 0 && len(path) >= DefaultParseOptions.MaxOpDepth {
+		return fail(
+			"more than %d nested operations", DefaultParseOptions.MaxOpDepth,
+		)
+	}
+	for _, l := range t.ops {
+		if l.opCode == nil || l.timestamp == nil {
+			return fail("incomplete operation")
+		}
+		next := l.timestamp.Message
+		if c, ok := l.opCode.(*cryptOp); ok && len(next) != c.digestLength {
+			return fail(
+				"%v result has %d bytes, want %d",
+				l.opCode, len(next), c.digestLength,
+			)
+		}
+		res, err := l.opCode.Execute(t.Message)
+		if err != nil {
+			return fail("%v: %v", l.opCode, err)
+		}
+		if !bytes.Equal(res, next) {
+			return fail("%v gives %x, not %x", l.opCode, res, next)
+		}
+		sub := append(path[:len(path):len(path)], l.opCode)
+		if err := l.timestamp.validateStructure(sub); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *Timestamp) encode(ctx *SerializationContext) error {
 	n := len(t.Attestations) + len(t.ops)
 	if n == 0 {
 		return fmt.Errorf("cannot encode empty timestamp")
 	}
-	prefixAtt := []byte{0x00}
+	prefixAtt := []byte{attestationMarker}
 	prefixOp := []byte{}
+	// every node except the last is preceded by a fork marker
 	nextNode := func(prefix []byte) error {
 		n -= 1
 		if n > 0 {
-			return ctx.writeByte(0xff)
+			if err := ctx.writeByte(forkMarker); err != nil {
+				return err
+			}
 		}
 		if len(prefix) > 0 {
-			return ctx.writeBytes(prefix)
+			return ctx.WriteBytes(prefix)
 		}
 		return nil
 	}
-	// FIXME attestations should be sorted
-	for _, att := range t.Attestations {
+	atts, ops := t.Attestations, t.ops
+	if ctx.canonical {
+		var err error
+		if atts, ops, err = t.canonicalOrder(); err != nil {
+			return err
+		}
+	}
+	for _, att := range atts {
 		if err := nextNode(prefixAtt); err != nil {
 			return err
 		}
@@ -67,8 +560,7 @@ func (t *Timestamp) encode(ctx *serializationContext) error {
 			return err
 		}
 	}
-	// FIXME ops should be sorted
-	for _, op := range t.ops {
+	for _, op := range ops {
 		if err := nextNode(prefixOp); err != nil {
 			return err
 		}
@@ -82,25 +574,199 @@ func (t *Timestamp) encode(ctx *serializationContext) error {
 	return nil
 }
 
-func (t *Timestamp) DumpIndent(w io.Writer, indent int, cfg dumpConfig) {
-	if cfg.showMessage {
-		fmt.Fprintf(w, strings.Repeat(" ", indent))
-		fmt.Fprintf(w, "message %x\n", t.Message)
+// canonicalOrder returns the attestations of t sorted by their encoding and
+// the operations sorted by their encoding. Equal operations are ordered by the
+// canonical encoding of the timestamp below them.
+func (t *Timestamp) canonicalOrder() ([]Attestation, []tsLink, error) {
+	attKeys := make([][]byte, len(t.Attestations))
+	for i, att := range t.Attestations {
+		encode := func(ctx *SerializationContext) error {
+			return encodeAttestation(ctx, att)
+		}
+		key, err := canonicalBytes(encode)
+		if err != nil {
+			return nil, nil, err
+		}
+		attKeys[i] = key
 	}
-	for _, att := range t.Attestations {
-		fmt.Fprint(w, strings.Repeat(" ", indent))
-		fmt.Fprintln(w, att)
+	atts := make([]Attestation, len(t.Attestations))
+	for i, k := range sortedOrder(attKeys, nil) {
+		atts[i] = t.Attestations[k]
+	}
+
+	opKeys := make([][]byte, len(t.ops))
+	childKeys := make([][]byte, len(t.ops))
+	for i, op := range t.ops {
+		key, err := canonicalBytes(op.opCode.encode)
+		if err != nil {
+			return nil, nil, err
+		}
+		opKeys[i] = key
+		// the branches are only needed to order equal operations
+		for j := 0; j < i; j++ {
+			if !bytes.Equal(opKeys[j], key) {
+				continue
+			}
+			for _, k := range []int{i, j} {
+				if childKeys[k] != nil {
+					continue
+				}
+				child := t.ops[k].timestamp
+				childKeys[k], err = canonicalBytes(child.encode)
+				if err != nil {
+					return nil, nil, err
+				}
+			}
+		}
+	}
+	ops := make([]tsLink, len(t.ops))
+	for i, k := range sortedOrder(opKeys, childKeys) {
+		ops[i] = t.ops[k]
+	}
+	return atts, ops, nil
+}
+
+// canonicalBytes returns the canonical encoding written by encode.
+func canonicalBytes(encode func(*SerializationContext) error) ([]byte, error) {
+	var buf bytes.Buffer
+	ctx := &SerializationContext{w: &buf, canonical: true}
+	if err := encode(ctx); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// sortedOrder returns the indices of keys in ascending order of keys, ties are
+// broken by tiebreak if it is not nil.
+func sortedOrder(keys, tiebreak [][]byte) []int {
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
 	}
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := order[i], order[j]
+		if c := bytes.Compare(keys[a], keys[b]); c != 0 || tiebreak == nil {
+			return c < 0
+		}
+		return bytes.Compare(tiebreak[a], tiebreak[b]) < 0
+	})
+	return order
+}
+
+// Serialize writes the timestamp in the binary format used in .ots files,
+// without the file header.
+func (t *Timestamp) Serialize(w io.Writer) error {
+	return t.encode(newSerializationContext(w))
+}
+
+// WriteTo implements io.WriterTo, it writes t like Serialize and returns the
+// number of bytes written.
+func (t *Timestamp) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	err := t.encode(newSerializationContext(cw))
+	return cw.n, err
+}
+
+// ReadFrom implements io.ReaderFrom, it replaces the attestations and
+// operations of t with the ones read from r for t.Message, using
+// DefaultParseOptions. r must end after the timestamp, otherwise
+// ErrTrailingBytes is returned. t is only modified if parsing succeeds.
+func (t *Timestamp) ReadFrom(r io.Reader) (int64, error) {
+	ctx := getDeserializationContext(r, DefaultParseOptions)
+	defer putDeserializationContext(ctx)
+	parsed, err := newTimestampFromContext(ctx, t.Message)
+	if err == nil && !ctx.assertEOF() {
+		err = fmt.Errorf("%w after timestamp", ErrTrailingBytes)
+	}
+	if err != nil {
+		return ctx.offset, err
+	}
+	t.Attestations, t.ops = parsed.Attestations, parsed.ops
+	return ctx.offset, nil
+}
+
+// SerializeCanonical is like Serialize but writes attestations and sibling
+// operations in a deterministic order, so timestamps that are Equal always
+// serialize to the same bytes.
+func (t *Timestamp) SerializeCanonical(w io.Writer) error {
+	return t.encode(&SerializationContext{w: w, canonical: true})
+}
+
+// SerializeToBytes returns the binary encoding written by Serialize.
+func (t *Timestamp) SerializeToBytes() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := t.Serialize(buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ToBase64 returns the binary encoding written by Serialize in standard
+// base64, e.g. for embedding in JSON or email. The message is not included,
+// FromBase64 needs it to decode the result.
+func (t *Timestamp) ToBase64() (string, error) {
+	return t.ToBase64WithEncoding(base64.StdEncoding)
+}
+
+// ToBase64WithEncoding is like ToBase64 but uses enc, e.g.
+// base64.RawURLEncoding for urls and QR codes.
+func (t *Timestamp) ToBase64WithEncoding(
+	enc *base64.Encoding,
+) (string, error) {
+	data, err := t.SerializeToBytes()
+	if err != nil {
+		return "", err
+	}
+	return enc.EncodeToString(data), nil
+}
+
+// FromBase64 decodes a timestamp for message from the standard base64
+// returned by ToBase64. Like ReadFrom it fails if there is data after the
+// timestamp.
+func FromBase64(s string, message []byte) (*Timestamp, error) {
+	return FromBase64WithEncoding(s, message, base64.StdEncoding)
+}
+
+// FromBase64WithEncoding is like FromBase64 but decodes s with enc.
+func FromBase64WithEncoding(
+	s string, message []byte, enc *base64.Encoding,
+) (*Timestamp, error) {
+	data, err := enc.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64: %w", err)
+	}
+	ts := &Timestamp{Message: message}
+	if _, err := ts.ReadFrom(bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	return ts, nil
+}
 
-	for _, tsLink := range t.ops {
-		fmt.Fprint(w, strings.Repeat(" ", indent))
-		fmt.Fprintln(w, tsLink.opCode)
-		// fmt.Fprint(w, strings.Repeat(" ", indent))
-		// if the timestamp is indeed tree-shaped, show it like that
-		if !cfg.showFlat || len(t.ops) > 1 {
-			indent += 1
+// DumpIndent writes the tree of t to w in the layout of the info command of
+// the reference implementation. Operations of a single path are written one
+// per line, followed by the attestations at its end. Where a timestamp has
+// several operations, each branch starts with " -> " and the rest of the
+// branch is indented by four more spaces.
+func (t *Timestamp) DumpIndent(w io.Writer, indent int, cfg dumpConfig) {
+	pad := strings.Repeat(" ", indent)
+	for _, att := range t.Attestations {
+		fmt.Fprintf(w, "%s%v\n", pad, att)
+		if _, ok := att.(*BitcoinAttestation); ok {
+			fmt.Fprintf(
+				w, "%s# Bitcoin block merkle root %s\n",
+				pad, ReverseHex(t.Message),
+			)
 		}
-		tsLink.timestamp.DumpIndent(w, indent, cfg)
+	}
+	if len(t.ops) == 1 {
+		l := t.ops[0]
+		fmt.Fprintf(w, "%s%v%s\n", pad, l.opCode, cfg.result(l.timestamp))
+		l.timestamp.DumpIndent(w, indent, cfg)
+		return
+	}
+	for _, l := range t.ops {
+		fmt.Fprintf(w, "%s -> %v%s\n", pad, l.opCode, cfg.result(l.timestamp))
+		l.timestamp.DumpIndent(w, indent+4, cfg)
 	}
 }
 
@@ -110,49 +776,52 @@ func (t *Timestamp) DumpWithConfig(cfg dumpConfig) string {
 	return b.String()
 }
 
+// Dump returns the tree of t as written by DumpIndent. The output only
+// depends on the structure of t, so it can be compared between versions.
 func (t *Timestamp) Dump() string {
 	return t.DumpWithConfig(defaultDumpConfig)
 }
 
 func parseTagOrAttestation(
 	ts *Timestamp,
-	ctx *deserializationContext,
+	ctx *DeserializationContext,
 	tag byte,
 	message []byte,
 	limit int,
 ) error {
-	if tag == 0x00 {
+	if tag == attestationMarker {
 		a, err := ParseAttestation(ctx)
 		if err != nil {
 			return err
 		}
 		ts.Attestations = append(ts.Attestations, a)
 	} else {
+		if limit == 0 {
+			return fmt.Errorf(
+				"%w: more than %d nested operations",
+				ErrOpDepthExceeded, ctx.opts.MaxOpDepth,
+			)
+		}
 		op, err := parseOp(ctx, tag)
 		if err != nil {
 			return err
 		}
-		newMessage, err := op.apply(message)
+		newMessage, err := op.Execute(message)
 		if err != nil {
 			return err
 		}
 		nextTs := &Timestamp{Message: newMessage}
-		err = parse(nextTs, ctx, newMessage, limit-1)
-		if err != nil {
-			return err
-		}
+		// linked before parsing so a failed parse leaves the partial
+		// subtree in place, see ParseLenientCollect
 		ts.ops = append(ts.ops, tsLink{op, nextTs})
-
+		return parse(nextTs, ctx, newMessage, limit-1)
 	}
 	return nil
 }
 
 func parse(
-	ts *Timestamp, ctx *deserializationContext, message []byte, limit int,
+	ts *Timestamp, ctx *DeserializationContext, message []byte, limit int,
 ) error {
-	if limit == 0 {
-		return fmt.Errorf("recursion limit")
-	}
 	var tag byte
 	var err error
 	for {
@@ -160,11 +829,17 @@ func parse(
 		if err != nil {
 			return err
 		}
-		if tag == 0xff {
+		if tag == forkMarker {
 			tag, err = ctx.readByte()
 			if err != nil {
 				return err
 			}
+			if tag == forkMarker {
+				return fmt.Errorf(
+					"%w: fork marker at offset %d follows a fork marker",
+					ErrUnexpectedMarker, ctx.offset-1,
+				)
+			}
 			err := parseTagOrAttestation(ts, ctx, tag, message, limit)
 			if err != nil {
 				return err
@@ -177,11 +852,12 @@ func parse(
 }
 
 func newTimestampFromContext(
-	ctx *deserializationContext, message []byte,
+	ctx *DeserializationContext, message []byte,
 ) (*Timestamp, error) {
-	recursionLimit := 1000
+	// The serialization is a tree, every operation is followed by its own
+	// subtree, so the depth limit also bounds the recursion.
 	ts := &Timestamp{Message: message}
-	err := parse(ts, ctx, message, recursionLimit)
+	err := parse(ts, ctx, message, ctx.opts.MaxOpDepth)
 	if err != nil {
 		return nil, err
 	}
@@ -191,3 +867,31 @@ func newTimestampFromContext(
 func NewTimestampFromReader(r io.Reader, message []byte) (*Timestamp, error) {
 	return newTimestampFromContext(newDeserializationContext(r), message)
 }
+
+// NewTimestampFromHashedReader hashes everything read from r with the hash
+// operation op and returns an empty timestamp for the digest. The input is
+// streamed, not loaded into memory.
+func NewTimestampFromHashedReader(
+	r io.Reader, op Operation,
+) (*Timestamp, error) {
+	hashOp, ok := op.(StreamingOperation)
+	if !ok {
+		return nil, fmt.Errorf("%v is not a hash operation", op)
+	}
+	digest, err := hashOp.ExecuteReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return &Timestamp{Message: digest}, nil
+}
+
+// NewTimestampFromFile returns an empty timestamp for the SHA256 digest of
+// the file at path.
+func NewTimestampFromFile(path string) (*Timestamp, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return NewTimestampFromHashedReader(f, OpSHA256)
+}
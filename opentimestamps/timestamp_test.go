@@ -0,0 +1,806 @@
+package opentimestamps
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// nestedTimestamp returns a serialized timestamp with depth nested REVERSE
+// operations leading to a single bitcoin attestation.
+func nestedTimestamp(t *testing.T, depth int) []byte {
+	buf := bytes.Repeat([]byte{OpReverse.tag}, depth)
+	buf = append(buf, 0x00)
+	return append(buf, encodeAttestationToBytes(t, NewBitcoinAttestation(1))...)
+}
+
+func TestOpDepthLimit(t *testing.T) {
+	opts := ParseOptions{MaxOpDepth: 10}
+	message := []byte{0x01, 0x02}
+
+	ctx := NewDeserializationContext(
+		bytes.NewBuffer(nestedTimestamp(t, 10)), opts,
+	)
+	_, err := newTimestampFromContext(ctx, message)
+	assert.NoError(t, err)
+
+	ctx = NewDeserializationContext(
+		bytes.NewBuffer(nestedTimestamp(t, 11)), opts,
+	)
+	_, err = newTimestampFromContext(ctx, message)
+	if assert.Error(t, err) {
+		assert.True(t, errors.Is(err, ErrOpDepthExceeded), err)
+	}
+
+	// the default limit applies without options
+	_, err = NewTimestampFromReader(
+		bytes.NewBuffer(nestedTimestamp(t, defaultMaxOpDepth+1)), message,
+	)
+	assert.True(t, errors.Is(err, ErrOpDepthExceeded), err)
+}
+
+// addOp links a new timestamp to ts via op and returns it.
+func addOp(t *testing.T, ts *Timestamp, op Operation) *Timestamp {
+	message, err := op.Execute(ts.Message)
+	require.NoError(t, err)
+	next := &Timestamp{Message: message}
+	ts.ops = append(ts.ops, tsLink{op, next})
+	return next
+}
+
+func TestMerge(t *testing.T) {
+	message := []byte{0x01, 0x02}
+	alice := NewPendingAttestation("https://alice.example.com")
+	bob := NewPendingAttestation("https://bob.example.com")
+
+	a := &Timestamp{Message: message}
+	addOp(t, a, OpAppend([]byte{0xaa})).Attestations = []Attestation{alice}
+
+	b := &Timestamp{Message: message}
+	leaf := addOp(t, b, OpAppend([]byte{0xaa}))
+	leaf.Attestations = []Attestation{alice, NewBitcoinAttestation(1)}
+	addOp(t, b, OpSHA256).Attestations = []Attestation{bob}
+
+	require.NoError(t, a.Merge(b))
+	require.Len(t, a.ops, 2)
+	assert.Equal(t,
+		[]Attestation{alice, NewBitcoinAttestation(1)},
+		a.ops[0].timestamp.Attestations,
+	)
+	assert.Equal(t, []Attestation{bob}, a.ops[1].timestamp.Attestations)
+
+	// merging again changes nothing
+	require.NoError(t, a.Merge(b))
+	assert.Len(t, a.ops, 2)
+	assert.Len(t, a.ops[0].timestamp.Attestations, 2)
+
+	// new branches are copied, changing a doesn't change b
+	before := b.Clone()
+	branch := a.ops[1].timestamp
+	require.NoError(t, a.AddAttestationAt(
+		branch.Message, NewBitcoinAttestation(2),
+	))
+	addOp(t, branch, OpSHA256).Attestations = []Attestation{alice}
+	branch.Message[0] ^= 0xff
+	assert.True(t, before.Equal(b))
+
+	err := a.Merge(&Timestamp{Message: []byte{0x03}})
+	assert.Error(t, err)
+	assert.Error(t, a.Merge(nil))
+}
+
+func TestDiff(t *testing.T) {
+	message := []byte{0x01, 0x02}
+	alice := NewPendingAttestation("https://alice.example.com")
+	bob := NewPendingAttestation("https://bob.example.com")
+
+	// overlapping, alice is in two branches of a
+	a := &Timestamp{Message: message}
+	addOp(t, a, OpAppend([]byte{0xaa})).Attestations = []Attestation{alice}
+	addOp(t, a, OpSHA256).Attestations = []Attestation{
+		NewPendingAttestation("https://alice.example.com"),
+		NewBitcoinAttestation(1),
+	}
+	b := &Timestamp{Message: message}
+	addOp(t, b, OpSHA256).Attestations = []Attestation{
+		NewBitcoinAttestation(1), bob,
+	}
+
+	onlyA, onlyB, common, err := Diff(a, b)
+	require.NoError(t, err)
+	assert.Equal(t, []Attestation{alice}, onlyA)
+	assert.Equal(t, []Attestation{bob}, onlyB)
+	assert.Equal(t, []Attestation{NewBitcoinAttestation(1)}, common)
+
+	// disjoint
+	c := &Timestamp{Message: message}
+	c.Attestations = []Attestation{NewBitcoinAttestation(2)}
+	onlyA, onlyB, common, err = Diff(b, c)
+	require.NoError(t, err)
+	assert.Equal(t, b.AllAttestations(), onlyA)
+	assert.Equal(t, c.Attestations, onlyB)
+	assert.Empty(t, common)
+
+	// nothing to gain from merging a timestamp into itself
+	onlyA, onlyB, common, err = Diff(a, a)
+	require.NoError(t, err)
+	assert.Empty(t, onlyA)
+	assert.Empty(t, onlyB)
+	assert.Len(t, common, 2)
+
+	_, _, _, err = Diff(a, &Timestamp{Message: []byte{0x03}})
+	assert.Error(t, err)
+}
+
+func TestSerializeCanonical(t *testing.T) {
+	message := []byte{0x01, 0x02}
+	proof := func(uri string, op Operation) *Timestamp {
+		ts := &Timestamp{Message: message}
+		leaf := addOp(t, ts, op)
+		leaf.Attestations = []Attestation{NewPendingAttestation(uri)}
+		return ts
+	}
+	newAlice := func() *Timestamp {
+		return proof("https://alice.example.com", OpAppend([]byte{0xaa}))
+	}
+	newBob := func() *Timestamp {
+		return proof("https://bob.example.com", OpSHA256)
+	}
+
+	ab := newAlice()
+	require.NoError(t, ab.Merge(newBob()))
+	ba := newBob()
+	require.NoError(t, ba.Merge(newAlice()))
+	require.True(t, ab.Equal(ba))
+
+	plainAB, err := ab.SerializeToBytes()
+	require.NoError(t, err)
+	plainBA, err := ba.SerializeToBytes()
+	require.NoError(t, err)
+	assert.NotEqual(t, plainAB, plainBA)
+
+	var canonicalAB, canonicalBA bytes.Buffer
+	require.NoError(t, ab.SerializeCanonical(&canonicalAB))
+	require.NoError(t, ba.SerializeCanonical(&canonicalBA))
+	assert.Equal(t, canonicalAB.Bytes(), canonicalBA.Bytes())
+
+	parsed, err := NewTimestampFromReader(&canonicalAB, message)
+	require.NoError(t, err)
+	assert.True(t, ab.Equal(parsed))
+
+	// equal operations are ordered by the branches below them
+	x, y := &Timestamp{Message: message}, &Timestamp{Message: message}
+	for _, uri := range []string{"https://a.example", "https://b.example"} {
+		addOp(t, x, OpSHA256).Attestations = []Attestation{
+			NewPendingAttestation(uri),
+		}
+	}
+	for _, uri := range []string{"https://b.example", "https://a.example"} {
+		addOp(t, y, OpSHA256).Attestations = []Attestation{
+			NewPendingAttestation(uri),
+		}
+	}
+	require.True(t, x.Equal(y))
+	var canonicalX, canonicalY bytes.Buffer
+	require.NoError(t, x.SerializeCanonical(&canonicalX))
+	require.NoError(t, y.SerializeCanonical(&canonicalY))
+	assert.Equal(t, canonicalX.Bytes(), canonicalY.Bytes())
+
+	// attestations are ordered by their encoding
+	pending := NewPendingAttestation("https://a.example")
+	x = &Timestamp{Message: message}
+	x.Attestations = []Attestation{pending, NewBitcoinAttestation(1)}
+	y = &Timestamp{Message: message}
+	y.Attestations = []Attestation{NewBitcoinAttestation(1), pending}
+	canonicalX.Reset()
+	canonicalY.Reset()
+	require.NoError(t, x.SerializeCanonical(&canonicalX))
+	require.NoError(t, y.SerializeCanonical(&canonicalY))
+	assert.Equal(t, canonicalX.Bytes(), canonicalY.Bytes())
+}
+
+func TestMultipleAttestationsRoundTrip(t *testing.T) {
+	path := "../examples/pending-and-bitcoin.txt.ots"
+	orig, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	dts, err := NewDetachedTimestampFromReader(bytes.NewReader(orig))
+	require.NoError(t, err)
+
+	leafAttestations := func(ts *Timestamp) (res []string) {
+		ts.WalkAttestations(func(path []Operation, a Attestation) {
+			assert.Len(t, path, 2)
+			res = append(res, a.String())
+		})
+		return
+	}
+	expected := []string{
+		NewPendingAttestation(
+			"https://alice.btc.calendar.opentimestamps.org",
+		).String(),
+		NewBitcoinAttestation(358391).String(),
+	}
+	assert.Equal(t, expected, leafAttestations(dts.Timestamp))
+
+	var buf bytes.Buffer
+	require.NoError(t, dts.WriteToStream(&buf))
+	assert.Equal(t, orig, buf.Bytes())
+	parsed, err := NewDetachedTimestampFromReader(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, expected, leafAttestations(parsed.Timestamp))
+}
+
+func TestForkMarkers(t *testing.T) {
+	for _, path := range []string{
+		"../examples/two-calendars.txt.ots",
+		"../examples/pending-and-bitcoin.txt.ots",
+		"../examples/merkle3.txt.ots",
+	} {
+		orig, err := ioutil.ReadFile(path)
+		require.NoError(t, err, path)
+		dts, err := NewDetachedTimestampFromReader(bytes.NewReader(orig))
+		require.NoError(t, err, path)
+		// one fork marker for each node but the last of every timestamp
+		forks := 0
+		dts.Timestamp.Walk(func(ts *Timestamp) {
+			if n := len(ts.Attestations) + len(ts.ops); n > 1 {
+				forks += n - 1
+			}
+		})
+		assert.NotZero(t, forks, path)
+		var buf bytes.Buffer
+		require.NoError(t, dts.WriteToStream(&buf), path)
+		assert.Equal(t, orig, buf.Bytes(), path)
+	}
+
+	bitcoin := append([]byte{attestationMarker}, bitcoinAttestationTag...)
+	bitcoin = append(bitcoin, 0x01, 0x01)
+	for name, tree := range map[string][]byte{
+		"fork after fork": append([]byte{forkMarker, forkMarker}, bitcoin...),
+		"unknown tag":     {0x42},
+		"unknown tag after fork": append(
+			append([]byte{forkMarker}, bitcoin...), 0x42,
+		),
+	} {
+		_, err := NewTimestampFromReader(bytes.NewReader(tree), []byte{0x01})
+		assert.True(t, errors.Is(err, ErrUnexpectedMarker), name)
+	}
+	// a fork marker must be followed by two nodes
+	_, err := NewTimestampFromReader(
+		bytes.NewReader(append([]byte{forkMarker}, bitcoin...)), []byte{0x01},
+	)
+	assert.True(t, errors.Is(err, ErrUnexpectedEOF))
+}
+
+func TestAllAttestations(t *testing.T) {
+	dts, err := NewDetachedTimestampFromPath(
+		"../examples/two-calendars.txt.ots",
+	)
+	require.NoError(t, err)
+	atts := dts.Attestations()
+	require.Len(t, atts, 2)
+	assert.True(t, NewPendingAttestation(
+		"https://alice.btc.calendar.opentimestamps.org",
+	).Equal(atts[0]))
+	assert.True(t, NewPendingAttestation(
+		"https://bob.btc.calendar.opentimestamps.org",
+	).Equal(atts[1]))
+
+	// duplicates in different branches are kept
+	ts := &Timestamp{Message: []byte{0x01}}
+	for _, op := range []Operation{OpSHA256, OpSHA1} {
+		addOp(t, ts, op).Attestations = []Attestation{
+			NewBitcoinAttestation(1),
+		}
+	}
+	assert.Equal(t, []Attestation{
+		NewBitcoinAttestation(1), NewBitcoinAttestation(1),
+	}, ts.AllAttestations())
+
+	assert.Empty(t, (&Timestamp{Message: []byte{0x01}}).AllAttestations())
+}
+
+func TestPendingURIs(t *testing.T) {
+	dts, err := NewDetachedTimestampFromPath(
+		"../examples/two-calendars.txt.ots",
+	)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"https://alice.btc.calendar.opentimestamps.org",
+		"https://bob.btc.calendar.opentimestamps.org",
+	}, dts.Timestamp.PendingURIs())
+
+	// duplicates are removed
+	ts := &Timestamp{Message: []byte{0x01}}
+	for _, op := range []Operation{OpSHA256, OpSHA1} {
+		addOp(t, ts, op).Attestations = []Attestation{
+			NewPendingAttestation("https://alice.example.com"),
+		}
+	}
+	assert.Equal(t,
+		[]string{"https://alice.example.com"}, ts.PendingURIs(),
+	)
+
+	dts, err = NewDetachedTimestampFromPath("../examples/hello-world.txt.ots")
+	require.NoError(t, err)
+	uris := dts.Timestamp.PendingURIs()
+	assert.NotNil(t, uris)
+	assert.Empty(t, uris)
+}
+
+func TestTimestampStatus(t *testing.T) {
+	for path, want := range map[string]Status{
+		"../examples/hello-world.txt.ots":              StatusComplete,
+		"../examples/two-calendars.txt.ots":            StatusPending,
+		"../examples/unknown-notary.txt.ots":           StatusUnknownOnly,
+		"../examples/known-and-unknown-notary.txt.ots": StatusPending,
+		"../examples/pending-and-bitcoin.txt.ots":      StatusComplete,
+	} {
+		dts, err := NewDetachedTimestampFromPath(path)
+		require.NoError(t, err, path)
+		assert.Equal(t, want, dts.Timestamp.Status(), path)
+	}
+	ts := &Timestamp{Message: []byte{0x01}}
+	assert.Equal(t, StatusEmpty, ts.Status())
+	ts.Attestations = []Attestation{
+		NewEthereumAttestation(1), NewPendingAttestation("https://a.example"),
+	}
+	assert.Equal(t, StatusComplete, ts.Status())
+	assert.Equal(t, "pending", StatusPending.String())
+}
+
+func TestSerializeRoundTrip(t *testing.T) {
+	dts, err := NewDetachedTimestampFromPath("../examples/incomplete.txt.ots")
+	require.NoError(t, err)
+
+	data, err := dts.Timestamp.SerializeToBytes()
+	require.NoError(t, err)
+	ts, err := NewTimestampFromReader(bytes.NewBuffer(data), dts.FileHash)
+	require.NoError(t, err)
+	assert.Equal(t, dts.Timestamp.Dump(), ts.Dump())
+
+	again, err := ts.SerializeToBytes()
+	require.NoError(t, err)
+	assert.Equal(t, data, again)
+
+	_, err = (&Timestamp{}).SerializeToBytes()
+	assert.Error(t, err)
+}
+
+func isBitcoinAttestation(att Attestation) bool {
+	_, ok := att.(*BitcoinAttestation)
+	return ok
+}
+
+func TestPrune(t *testing.T) {
+	path := "../examples/hello-world.txt.ots"
+	dts, err := NewDetachedTimestampFromPath(path)
+	require.NoError(t, err)
+	want, err := dts.Timestamp.SerializeToBytes()
+	require.NoError(t, err)
+
+	// add a pending attestation and a longer branch to a bitcoin attestation
+	ts := dts.Timestamp
+	ts.Attestations = append(
+		ts.Attestations, NewPendingAttestation("https://alice.example.com"),
+	)
+	longer := ts
+	for i := 0; i < 100; i++ {
+		longer = addOp(t, longer, OpSHA256)
+	}
+	longer.Attestations = []Attestation{NewBitcoinAttestation(1)}
+
+	require.NoError(t, ts.Prune(isBitcoinAttestation))
+	got, err := ts.SerializeToBytes()
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+
+	var heights []uint64
+	ts.Walk(func(ts *Timestamp) {
+		for _, att := range ts.Attestations {
+			heights = append(heights, att.(*BitcoinAttestation).Height())
+		}
+	})
+	assert.Equal(t, []uint64{358391}, heights)
+
+	err = ts.Prune(func(Attestation) bool { return false })
+	assert.Error(t, err)
+	got, err = ts.SerializeToBytes()
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+}
+
+func TestValidateStructure(t *testing.T) {
+	for _, name := range []string{
+		"hello-world", "two-calendars", "pending-and-bitcoin",
+		"merkle1", "merkle2", "merkle3", "unknown-notary",
+	} {
+		dts, err := NewDetachedTimestampFromPath(
+			"../examples/" + name + ".txt.ots",
+		)
+		require.NoError(t, err, name)
+		assert.NoError(t, dts.Timestamp.ValidateStructure(), name)
+	}
+
+	message := []byte{0x01, 0x02}
+	newValid := func() (*Timestamp, *Timestamp) {
+		ts := &Timestamp{Message: message}
+		leaf := addOp(t, addOp(t, ts, OpAppend([]byte{0xaa})), OpSHA256)
+		leaf.Attestations = []Attestation{NewBitcoinAttestation(1)}
+		return ts, leaf
+	}
+	ts, _ := newValid()
+	require.NoError(t, ts.ValidateStructure())
+
+	// a message that isn't the result of the operation
+	ts, leaf := newValid()
+	leaf.Message = newTestDigest("other")
+	err := ts.ValidateStructure()
+	assert.True(t, errors.Is(err, ErrInvalidStructure), err)
+	assert.Contains(t, err.Error(), "at APPEND aa: SHA256 gives")
+
+	// a truncated digest
+	ts, leaf = newValid()
+	leaf.Message = leaf.Message[:31]
+	err = ts.ValidateStructure()
+	assert.True(t, errors.Is(err, ErrInvalidStructure), err)
+	assert.Contains(t, err.Error(), "SHA256 result has 31 bytes, want 32")
+
+	// a branch without attestation
+	ts, _ = newValid()
+	addOp(t, ts, OpReverse)
+	err = ts.ValidateStructure()
+	assert.True(t, errors.Is(err, ErrInvalidStructure), err)
+	assert.Contains(t, err.Error(), "at REVERSE: branch without attestation")
+	err = (&Timestamp{Message: message}).ValidateStructure()
+	assert.Contains(t, err.Error(), "at root: branch without attestation")
+
+	// nested deeper than a parse accepts
+	ts = &Timestamp{Message: message}
+	last := ts
+	for i := 0; i <= DefaultParseOptions.MaxOpDepth; i++ {
+		last = addOp(t, last, OpReverse)
+	}
+	last.Attestations = []Attestation{NewBitcoinAttestation(1)}
+	err = ts.ValidateStructure()
+	assert.True(t, errors.Is(err, ErrInvalidStructure), err)
+	assert.Contains(t, err.Error(), "nested operations")
+}
+
+func TestBase64(t *testing.T) {
+	for _, name := range []string{
+		"hello-world", "two-calendars", "unknown-notary",
+		"known-and-unknown-notary",
+	} {
+		dts, err := NewDetachedTimestampFromPath(
+			"../examples/" + name + ".txt.ots",
+		)
+		require.NoError(t, err, name)
+		ts := dts.Timestamp
+		raw, err := ts.SerializeToBytes()
+		require.NoError(t, err, name)
+
+		s, err := ts.ToBase64()
+		require.NoError(t, err, name)
+		assert.Equal(t, base64.StdEncoding.EncodeToString(raw), s, name)
+		decoded, err := FromBase64(s, ts.Message)
+		require.NoError(t, err, name)
+		assert.True(t, ts.Equal(decoded), name)
+		again, err := decoded.SerializeToBytes()
+		require.NoError(t, err, name)
+		assert.Equal(t, raw, again, name)
+
+		s, err = ts.ToBase64WithEncoding(base64.RawURLEncoding)
+		require.NoError(t, err, name)
+		assert.NotContains(t, s, "+", name)
+		assert.NotContains(t, s, "/", name)
+		decoded, err = FromBase64WithEncoding(
+			s, ts.Message, base64.RawURLEncoding,
+		)
+		require.NoError(t, err, name)
+		assert.True(t, ts.Equal(decoded), name)
+	}
+
+	message := []byte{0x01, 0x02}
+	_, err := FromBase64("not base64!", message)
+	assert.Error(t, err)
+	ts := &Timestamp{Message: message}
+	ts.Attestations = []Attestation{NewBitcoinAttestation(1)}
+	raw, err := ts.SerializeToBytes()
+	require.NoError(t, err)
+	_, err = FromBase64(
+		base64.StdEncoding.EncodeToString(append(raw, 0x00)), message,
+	)
+	assert.True(t, errors.Is(err, ErrTrailingBytes), err)
+}
+
+func TestExtractPath(t *testing.T) {
+	dts, err := NewDetachedTimestampFromPath("../examples/hello-world.txt.ots")
+	require.NoError(t, err)
+	original, err := dts.Timestamp.SerializeToBytes()
+	require.NoError(t, err)
+	bitcoin := dts.Attestations()[0]
+
+	// add a pending attestation and another branch
+	ts := dts.Timestamp
+	pending := NewPendingAttestation("https://alice.example.com")
+	ts.Attestations = append(ts.Attestations, pending)
+	addOp(t, ts, OpSHA1).Attestations = []Attestation{
+		NewBitcoinAttestation(1),
+	}
+
+	extracted, err := ts.ExtractPath(bitcoin)
+	require.NoError(t, err)
+	got, err := extracted.SerializeToBytes()
+	require.NoError(t, err)
+	assert.Equal(t, original, got)
+	verified, err := extracted.Verify(context.Background(), newTestBackend())
+	require.NoError(t, err)
+	assert.Equal(t, helloWorldTime, verified)
+
+	// looked up by Equal
+	extracted, err = ts.ExtractPath(
+		NewPendingAttestation("https://alice.example.com"),
+	)
+	require.NoError(t, err)
+	assert.Equal(t, []Attestation{pending}, extracted.AllAttestations())
+	assert.Equal(t, ts.Message, extracted.Message)
+
+	_, err = ts.ExtractPath(NewBitcoinAttestation(2))
+	assert.Error(t, err)
+	_, err = ts.ExtractPath(nil)
+	assert.Error(t, err)
+
+	// attestation values that can't be compared are looked up by Equal
+	note := valueAttestation{[]byte("note")}
+	addOp(t, ts, OpSHA256).Attestations = []Attestation{note}
+	extracted, err = ts.ExtractPath(valueAttestation{[]byte("note")})
+	require.NoError(t, err)
+	assert.Equal(t, []Attestation{note}, extracted.AllAttestations())
+}
+
+// valueAttestation is an attestation implemented by a struct value that
+// can't be compared with ==.
+type valueAttestation struct {
+	note []byte
+}
+
+func (v valueAttestation) String() string {
+	return fmt.Sprintf("valueAttestation(%q)", v.note)
+}
+
+func (v valueAttestation) Tag() []byte {
+	return []byte("value\x01\x02\x03")
+}
+
+func (v valueAttestation) Decode(
+	ctx *DeserializationContext,
+) (Attestation, error) {
+	note, err := ctx.ReadVarBytes(0, 64)
+	return valueAttestation{copyBytes(note)}, err
+}
+
+func (v valueAttestation) Encode(ctx *SerializationContext) error {
+	return ctx.WriteVarBytes(v.note)
+}
+
+func (v valueAttestation) Equal(other Attestation) bool {
+	o, ok := other.(valueAttestation)
+	return ok && bytes.Equal(v.note, o.note)
+}
+
+func TestAddAttestationAt(t *testing.T) {
+	dts, err := NewDetachedTimestampFromPath(
+		"../examples/two-calendars.txt.ots",
+	)
+	require.NoError(t, err)
+	ts := dts.Timestamp
+	pending := PendingTimestamps(ts)
+	require.Len(t, pending, 2)
+	commitment := pending[1].Timestamp.Message
+
+	bitcoin := NewBitcoinAttestation(358391)
+	require.NoError(t, ts.AddAttestationAt(commitment, bitcoin))
+	assert.Equal(t, StatusComplete, ts.Status())
+	leaf := pending[1].Timestamp
+	assert.Len(t, leaf.Attestations, 2)
+	assert.True(t, bitcoin.Equal(leaf.Attestations[1]))
+	assert.Len(t, pending[0].Timestamp.Attestations, 1)
+
+	// already present
+	err = ts.AddAttestationAt(commitment, NewBitcoinAttestation(358391))
+	assert.Error(t, err)
+	// the root is not a leaf
+	err = ts.AddAttestationAt(ts.Message, NewBitcoinAttestation(1))
+	assert.Error(t, err)
+	err = ts.AddAttestationAt([]byte{0x01}, NewBitcoinAttestation(1))
+	assert.Error(t, err)
+}
+
+func TestDump(t *testing.T) {
+	dts, err := NewDetachedTimestampFromPath(
+		"../examples/two-calendars.txt.ots",
+	)
+	require.NoError(t, err)
+	want := `File SHA256 hash: ` +
+		`efaa174f68e59705757460f4f7d204bd2b535cfd194d9d945418732129404ddb
+Timestamp:
+APPEND 839037eef449dec6dac322ca97347c45
+SHA256
+ -> APPEND 6b4023b6edd3a0eeeb09e5d718723b9e
+    SHA256
+    PREPEND 57d46515
+    APPEND eadd66b1688d5574
+    VERIFY PendingAttestation(url=https://alice.btc.calendar.opentimestamps.org)
+ -> APPEND a3ad701ef9f10535a84968b5a99d8580
+    SHA256
+    PREPEND 57d46516
+    APPEND 647b90ea1b270a97
+    VERIFY PendingAttestation(url=https://bob.btc.calendar.opentimestamps.org)
+`
+	assert.Equal(t, want, dts.Dump())
+
+	dts, err = NewDetachedTimestampFromPath(
+		"../examples/pending-and-bitcoin.txt.ots",
+	)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(dts.Timestamp.Dump()), "\n")
+	assert.Equal(t, []string{
+		"APPEND 7a1c52e1b4b0e1a69d02",
+		"SHA256",
+		"VERIFY PendingAttestation(" +
+			"url=https://alice.btc.calendar.opentimestamps.org)",
+		"VERIFY BitcoinAttestation(height=358391)",
+		"# Bitcoin block merkle root " +
+			"a219acf961232f44f329ca2067026336fcc1ca7230fbe7ffcc50bd3a38ea4651",
+	}, lines)
+
+	verbose := dts.Timestamp.DumpWithConfig(dumpConfig{showMessage: true})
+	assert.Contains(t, verbose, "SHA256 == "+
+		"5146ea383abd50ccffe7fb3072cac1fc3663026720ca29f3442f2361f9ac19a2\n")
+}
+
+func TestNewTimestampFromFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ots-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	content := bytes.Repeat([]byte("hello world\n"), 10000)
+	path := filepath.Join(dir, "file.txt")
+	require.NoError(t, ioutil.WriteFile(path, content, 0644))
+
+	ts, err := NewTimestampFromFile(path)
+	require.NoError(t, err)
+	digest := sha256.Sum256(content)
+	assert.Equal(t, digest[:], ts.Message)
+
+	ts, err = NewTimestampFromHashedReader(bytes.NewBuffer(content), OpKeccak256)
+	require.NoError(t, err)
+	want, err := msgKeccak256(content)
+	require.NoError(t, err)
+	assert.Equal(t, want, ts.Message)
+
+	_, err = NewTimestampFromHashedReader(bytes.NewBuffer(content), OpReverse)
+	assert.Error(t, err)
+	_, err = NewTimestampFromFile(filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+}
+
+func TestNewTimestampFromLargeFile(t *testing.T) {
+	if testing.Short() {
+		t.Skip("writes a large file")
+	}
+	const size = 256 << 20
+	f, err := ioutil.TempFile("", "ots-large")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	defer f.Close()
+	// sparse, reads back as zeros
+	require.NoError(t, f.Truncate(size))
+
+	want := sha256.New()
+	zeros := make([]byte, 1<<20)
+	for i := 0; i < size/len(zeros); i++ {
+		want.Write(zeros)
+	}
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	ts, err := NewTimestampFromFile(f.Name())
+	runtime.ReadMemStats(&after)
+	require.NoError(t, err)
+	assert.Equal(t, want.Sum(nil), ts.Message)
+	assert.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(1<<20))
+
+	for _, op := range []Operation{
+		OpSHA1, OpRIPEMD160, OpSHA256, OpKeccak256,
+	} {
+		_, ok := op.(StreamingOperation)
+		assert.True(t, ok, op.String())
+	}
+	_, ok := Operation(OpAppend([]byte{0x01})).(StreamingOperation)
+	assert.False(t, ok)
+}
+
+func TestWalkAttestations(t *testing.T) {
+	dts, err := NewDetachedTimestampFromPath("../examples/hello-world.txt.ots")
+	require.NoError(t, err)
+
+	var atts []Attestation
+	dts.Timestamp.WalkAttestations(func(path []Operation, a Attestation) {
+		atts = append(atts, a)
+		message, err := ComputeResult(dts.Timestamp.Message, path)
+		require.NoError(t, err)
+		assert.Equal(t, mustDecodeHex(
+			"007ee445d23ad061af4a36b809501fab1ac4f2d7e7a739817dd0cbb7ec661b8a",
+		), message)
+	})
+	assert.Equal(t, []Attestation{NewBitcoinAttestation(358391)}, atts)
+
+	dts, err = NewDetachedTimestampFromPath("../examples/two-calendars.txt.ots")
+	require.NoError(t, err)
+	var uris []string
+	dts.Timestamp.WalkAttestations(func(path []Operation, a Attestation) {
+		assert.NotEmpty(t, path)
+		uris = append(uris, a.(*PendingAttestation).URI())
+	})
+	assert.Len(t, uris, 2)
+}
+
+func TestTimestampEqual(t *testing.T) {
+	for _, path := range examplePaths() {
+		dts, err := NewDetachedTimestampFromPath(path)
+		require.NoError(t, err, path)
+		data, err := dts.Timestamp.SerializeToBytes()
+		require.NoError(t, err, path)
+		ts, err := NewTimestampFromReader(bytes.NewBuffer(data), dts.FileHash)
+		require.NoError(t, err, path)
+		assert.True(t, dts.Timestamp.Equal(ts), path)
+		assert.True(t, ts.Equal(dts.Timestamp), path)
+	}
+
+	message := []byte{0x01}
+	alice := NewPendingAttestation("https://alice.example.com")
+	bob := NewPendingAttestation("https://bob.example.com")
+	a := &Timestamp{Message: message, Attestations: []Attestation{alice, bob}}
+	addOp(t, a, OpSHA256).Attestations = []Attestation{alice}
+	addOp(t, a, OpReverse).Attestations = []Attestation{bob}
+
+	// same branches and attestations in a different order
+	b := &Timestamp{Message: message, Attestations: []Attestation{bob, alice}}
+	addOp(t, b, OpReverse).Attestations = []Attestation{bob}
+	addOp(t, b, OpSHA256).Attestations = []Attestation{alice}
+	assert.True(t, a.Equal(b))
+
+	b.ops[0].timestamp.Attestations = []Attestation{alice}
+	assert.False(t, a.Equal(b))
+	assert.False(t, a.Equal(&Timestamp{Message: message}))
+	assert.False(t, a.Equal(nil))
+}
+
+func BenchmarkSerializeRoundTrip(b *testing.B) {
+	dts, err := NewDetachedTimestampFromPath("../examples/hello-world.txt.ots")
+	require.NoError(b, err)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		data, err := dts.Timestamp.SerializeToBytes()
+		if err != nil {
+			b.Fatal(err)
+		}
+		_, err = NewTimestampFromReader(bytes.NewReader(data), dts.FileHash)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package opentimestamps
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// UpgradeOptions configures UpgradeAll. Zero values are replaced by the
+// corresponding value of DefaultUpgradeOptions.
+type UpgradeOptions struct {
+	// Concurrency is the maximum number of calendar requests in flight.
+	Concurrency int
+	// Calendar configures the calendar clients.
+	Calendar CalendarOptions
+}
+
+// DefaultUpgradeOptions are used for unset fields of UpgradeOptions.
+var DefaultUpgradeOptions = UpgradeOptions{
+	Concurrency: 8,
+}
+
+// withDefaults returns a copy of o with unset fields taken from
+// DefaultUpgradeOptions.
+func (o UpgradeOptions) withDefaults() UpgradeOptions {
+	if o.Concurrency <= 0 {
+		o.Concurrency = DefaultUpgradeOptions.Concurrency
+	}
+	if o.Concurrency <= 0 {
+		o.Concurrency = 1
+	}
+	o.Calendar = o.Calendar.withDefaults()
+	return o
+}
+
+// upgradeRequest is a commitment to fetch from a calendar, with the leaves of
+// all timestamps waiting for it.
+type upgradeRequest struct {
+	uri        string
+	commitment []byte
+	leaves     []*Timestamp
+	// indices of the timestamps the leaves belong to
+	owners []int
+	result *Timestamp
+	err    error
+}
+
+// UpgradeAll fetches the complete timestamps for the pending attestations of
+// timestamps and merges them in place, like PendingTimestamp.Upgrade does for
+// a single one. Timestamps that are already complete are skipped, and each
+// commitment is requested once per calendar even if several timestamps share
+// it. At most opts.Concurrency requests are sent at the same time.
+//
+// upgraded is the number of timestamps that received at least one upgrade.
+// Failing calendars don't stop the others, their errors are returned in errs.
+// Commitments the calendars haven't confirmed yet are not errors. If ctx is
+// done before all requests are sent, its error is included once in errs.
+func UpgradeAll(
+	ctx context.Context, timestamps []*Timestamp, opts UpgradeOptions,
+) (upgraded int, errs []error) {
+	opts = opts.withDefaults()
+
+	var requests []*upgradeRequest
+	byKey := make(map[string]*upgradeRequest)
+	for i, ts := range timestamps {
+		if ts.Status() == StatusComplete {
+			continue
+		}
+		for _, p := range PendingTimestamps(ts) {
+			uri := p.PendingAttestation.URI()
+			key := uri + " " + hex.EncodeToString(p.Timestamp.Message)
+			req, ok := byKey[key]
+			if !ok {
+				req = &upgradeRequest{uri: uri, commitment: p.Timestamp.Message}
+				byKey[key] = req
+				requests = append(requests, req)
+			}
+			req.leaves = append(req.leaves, p.Timestamp)
+			req.owners = append(req.owners, i)
+		}
+	}
+
+	calendars := make(map[string]*RemoteCalendar)
+	sem := make(chan struct{}, opts.Concurrency)
+	var wg sync.WaitGroup
+	var ctxErr error
+	for _, req := range requests {
+		cal, ok := calendars[req.uri]
+		if !ok {
+			var err error
+			if cal, err = NewRemoteCalendarWithOptions(
+				req.uri, opts.Calendar,
+			); err != nil {
+				req.err = err
+				continue
+			}
+			calendars[req.uri] = cal
+		}
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			ctxErr = ctx.Err()
+		}
+		if ctxErr != nil {
+			break
+		}
+		wg.Add(1)
+		go func(req *upgradeRequest, cal *RemoteCalendar) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			req.result, req.err = cal.GetTimestamp(ctx, req.commitment)
+		}(req, cal)
+	}
+	wg.Wait()
+
+	// merged sequentially, leaves of different requests can share nodes
+	changed := make(map[int]bool)
+	for _, req := range requests {
+		if errors.Is(req.err, ErrCommitmentNotFound) {
+			continue
+		}
+		if req.err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", req.uri, req.err))
+			continue
+		}
+		if req.result == nil {
+			// not sent
+			continue
+		}
+		for i, leaf := range req.leaves {
+			if err := leaf.Merge(req.result); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", req.uri, err))
+				continue
+			}
+			changed[req.owners[i]] = true
+		}
+	}
+	if ctxErr != nil {
+		errs = append(errs, ctxErr)
+	}
+	return len(changed), errs
+}
@@ -0,0 +1,189 @@
+package opentimestamps
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newUpgradeCalendar returns a server with complete timestamps for the
+// commitments in confirmed and counts the requests for each commitment. It
+// fails the test if more than maxInFlight requests are handled at once.
+func newUpgradeCalendar(
+	t *testing.T, confirmed [][]byte, maxInFlight int32,
+) (*httptest.Server, func(commitment []byte) int) {
+	var mu sync.Mutex
+	requests := make(map[string]int)
+	var inFlight int32
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			n := atomic.AddInt32(&inFlight, 1)
+			defer atomic.AddInt32(&inFlight, -1)
+			assert.LessOrEqual(t, n, maxInFlight)
+			time.Sleep(5 * time.Millisecond)
+
+			commitment := strings.TrimPrefix(r.URL.Path, "/timestamp/")
+			mu.Lock()
+			requests[commitment]++
+			mu.Unlock()
+			for _, c := range confirmed {
+				if hex.EncodeToString(c) != commitment {
+					continue
+				}
+				ts := &Timestamp{Message: c}
+				ts.Attestations = []Attestation{NewBitcoinAttestation(1)}
+				assert.NoError(t, ts.Serialize(w))
+				return
+			}
+			http.NotFound(w, r)
+		},
+	))
+	count := func(commitment []byte) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests[hex.EncodeToString(commitment)]
+	}
+	return server, count
+}
+
+func TestUpgradeAll(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	var confirmed [][]byte
+	for i := 0; i < 10; i++ {
+		confirmed = append(confirmed, newTestDigest(string(rune('a'+i))))
+	}
+	pending := newTestDigest("pending")
+	server, count := newUpgradeCalendar(t, confirmed, 2)
+	defer server.Close()
+
+	var timestamps []*Timestamp
+	for _, c := range confirmed {
+		ts := &Timestamp{Message: c}
+		ts.Attestations = []Attestation{NewPendingAttestation(server.URL)}
+		timestamps = append(timestamps, ts)
+	}
+	// shares the commitment of the first timestamp and also uses a calendar
+	// that is down
+	shared := &Timestamp{Message: confirmed[0]}
+	shared.Attestations = []Attestation{
+		NewPendingAttestation(server.URL), NewPendingAttestation(down.URL),
+	}
+	notConfirmed := &Timestamp{Message: pending}
+	notConfirmed.Attestations = []Attestation{
+		NewPendingAttestation(server.URL),
+	}
+	complete := &Timestamp{Message: newTestDigest("complete")}
+	complete.Attestations = []Attestation{
+		NewPendingAttestation(server.URL), NewBitcoinAttestation(2),
+	}
+	timestamps = append(timestamps, shared, notConfirmed, complete)
+
+	upgraded, errs := UpgradeAll(
+		context.Background(), timestamps, UpgradeOptions{
+			Concurrency: 2,
+			Calendar: CalendarOptions{
+				MaxAttempts: 1, BaseDelay: time.Millisecond,
+			},
+		},
+	)
+	assert.Equal(t, len(confirmed)+1, upgraded)
+	require.Len(t, errs, 1)
+	assert.Contains(t, errs[0].Error(), down.URL)
+
+	for _, ts := range timestamps[:len(confirmed)+1] {
+		assert.Equal(t, StatusComplete, ts.Status())
+	}
+	assert.Equal(t, StatusPending, notConfirmed.Status())
+	assert.Equal(t, 1, count(confirmed[0]))
+	assert.Equal(t, 1, count(pending))
+	assert.Equal(t, 0, count(complete.Message))
+}
+
+func TestUpgradeAllNoSharedResult(t *testing.T) {
+	commitment := newTestDigest("shared")
+	result, err := NewBuilder(commitment).
+		SHA256().
+		Attest(NewBitcoinAttestation(1)).
+		Build()
+	require.NoError(t, err)
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			assert.NoError(t, result.Serialize(w))
+		},
+	))
+	defer server.Close()
+
+	var timestamps []*Timestamp
+	for i := 0; i < 2; i++ {
+		ts := &Timestamp{Message: commitment}
+		ts.Attestations = []Attestation{NewPendingAttestation(server.URL)}
+		timestamps = append(timestamps, ts)
+	}
+	upgraded, errs := UpgradeAll(
+		context.Background(), timestamps, UpgradeOptions{},
+	)
+	require.Empty(t, errs)
+	require.Equal(t, 2, upgraded)
+
+	a, b := timestamps[0], timestamps[1]
+	require.Len(t, a.ops, 1)
+	require.Len(t, b.ops, 1)
+	a.ops[0].timestamp.Attestations = append(
+		a.ops[0].timestamp.Attestations, NewBitcoinAttestation(2),
+	)
+	a.ops[0].timestamp.Message[0] ^= 0xff
+	assert.Len(t, a.AllAttestations(), 3)
+	assert.Len(t, b.AllAttestations(), 2)
+	assert.Equal(t, result.ops[0].timestamp.Message,
+		b.ops[0].timestamp.Message,
+	)
+	assert.True(t, result.Equal(&Timestamp{
+		Message: commitment, ops: b.ops,
+	}))
+}
+
+func TestUpgradeAllDeadline(t *testing.T) {
+	block := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-block:
+			case <-r.Context().Done():
+			}
+		},
+	))
+	defer server.Close()
+	defer close(block)
+
+	var timestamps []*Timestamp
+	for i := 0; i < 5; i++ {
+		ts := &Timestamp{Message: newTestDigest(string(rune('a' + i)))}
+		ts.Attestations = []Attestation{NewPendingAttestation(server.URL)}
+		timestamps = append(timestamps, ts)
+	}
+	ctx, cancel := context.WithTimeout(
+		context.Background(), 50*time.Millisecond,
+	)
+	defer cancel()
+	start := time.Now()
+	upgraded, errs := UpgradeAll(ctx, timestamps, UpgradeOptions{
+		Concurrency: 1,
+		Calendar:    CalendarOptions{MaxAttempts: 1},
+	})
+	assert.True(t, time.Since(start) < time.Minute)
+	assert.Zero(t, upgraded)
+	require.NotEmpty(t, errs)
+	assert.True(t, errors.Is(errs[len(errs)-1], context.DeadlineExceeded))
+}
@@ -9,3 +9,20 @@ func mustDecodeHex(in string) []byte {
 	}
 	return out
 }
+
+// copyBytes returns a copy of b that does not share the backing array.
+func copyBytes(b []byte) []byte {
+	return append([]byte{}, b...)
+}
+
+// ReverseHex returns the hex encoding of b in reverse byte order. Hashes in
+// this package, e.g. the merkle roots returned by ComputeResult, are in the
+// byte order used in block headers. Block explorers and bitcoind show them
+// reversed, ReverseHex converts them for display.
+func ReverseHex(b []byte) string {
+	r := make([]byte, len(b))
+	for i, c := range b {
+		r[len(b)-1-i] = c
+	}
+	return hex.EncodeToString(r)
+}
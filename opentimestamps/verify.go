@@ -0,0 +1,336 @@
+package opentimestamps
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// A Chain identifies the blockchain an attestation refers to.
+type Chain int
+
+const (
+	ChainBitcoin Chain = iota
+	ChainLitecoin
+)
+
+func (c Chain) String() string {
+	switch c {
+	case ChainBitcoin:
+		return "bitcoin"
+	case ChainLitecoin:
+		return "litecoin"
+	default:
+		return fmt.Sprintf("Chain(%d)", int(c))
+	}
+}
+
+// A Network is a bitcoin network. Attestations don't record the network they
+// were made on, it is chosen by the backend that verifies them.
+type Network int
+
+const (
+	NetworkMainnet Network = iota
+	NetworkTestnet
+	NetworkSignet
+)
+
+func (n Network) String() string {
+	switch n {
+	case NetworkMainnet:
+		return "mainnet"
+	case NetworkTestnet:
+		return "testnet"
+	case NetworkSignet:
+		return "signet"
+	default:
+		return fmt.Sprintf("Network(%d)", int(n))
+	}
+}
+
+// A BlockHeader holds the parts of a block header needed to verify an
+// attestation.
+type BlockHeader struct {
+	// MerkleRoot is in the byte order of the serialized header, which is
+	// the reverse of the hex string shown by block explorers.
+	MerkleRoot []byte
+	Time       time.Time
+}
+
+// A VerificationBackend looks up block headers, e.g. from a full node or a
+// block explorer. Implementations are in the client package.
+type VerificationBackend interface {
+	BlockHeader(ctx context.Context, height uint64) (*BlockHeader, error)
+}
+
+// A ChainBackend is a VerificationBackend for a chain other than bitcoin.
+// Backends that don't implement it are used for bitcoin attestations.
+type ChainBackend interface {
+	VerificationBackend
+	Chain() Chain
+}
+
+func backendChain(backend VerificationBackend) Chain {
+	if c, ok := backend.(ChainBackend); ok {
+		return c.Chain()
+	}
+	return ChainBitcoin
+}
+
+// A NetworkBackend is a VerificationBackend that reports the network it looks
+// up blocks on. Backends that don't implement it are assumed to use mainnet.
+type NetworkBackend interface {
+	VerificationBackend
+	BitcoinNetwork() Network
+}
+
+func backendNetwork(backend VerificationBackend) Network {
+	if n, ok := backend.(NetworkBackend); ok {
+		return n.BitcoinNetwork()
+	}
+	return NetworkMainnet
+}
+
+// verifyBlock checks that root is the merkle root of the block at height and
+// returns the time of the block.
+func verifyBlock(
+	ctx context.Context, backend VerificationBackend,
+	height uint64, root []byte,
+) (time.Time, error) {
+	header, err := backend.BlockHeader(ctx, height)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !bytes.Equal(root, header.MerkleRoot) {
+		return time.Time{}, fmt.Errorf(
+			"%w: digest=%x merkleRoot=%x",
+			ErrMerkleRootMismatch, root, header.MerkleRoot,
+		)
+	}
+	return header.Time.UTC(), nil
+}
+
+// VerifyBitcoinAttestation checks that the block at the height of att has
+// root as its merkle root and returns the time of the block. root is the
+// message the attestation belongs to.
+func VerifyBitcoinAttestation(
+	ctx context.Context, backend VerificationBackend,
+	att *BitcoinAttestation, root []byte,
+) (time.Time, error) {
+	return verifyBlock(ctx, backend, att.Height(), root)
+}
+
+// Verify checks the bitcoin and litecoin attestations of t and returns the
+// earliest block time. Each attestation is checked with the first backend for
+// its chain, attestations without a backend and unknown attestations are
+// skipped. ErrIncomplete is returned if t has no attestation that could be
+// verified, e.g. because it only has pending attestations and needs to be
+// upgraded first.
+func (t *Timestamp) Verify(
+	ctx context.Context, backends ...VerificationBackend,
+) (time.Time, error) {
+	return t.VerifyWithOptions(ctx, DefaultVerifyOptions, backends...)
+}
+
+// VerifyOptions configures Timestamp.VerifyWithOptions.
+type VerifyOptions struct {
+	// Logger receives verified blocks and skipped attestations. Nothing is
+	// logged if nil.
+	Logger Logger
+	// RejectUnknown returns an error wrapping ErrUnknownAttestation if t
+	// has attestations of unknown types. By default they are skipped.
+	RejectUnknown bool
+}
+
+// DefaultVerifyOptions are used by Verify.
+var DefaultVerifyOptions = VerifyOptions{}
+
+// VerifyWithOptions is like Verify but configured by opts. t is not modified,
+// unknown attestations are kept either way.
+func (t *Timestamp) VerifyWithOptions(
+	ctx context.Context, opts VerifyOptions,
+	backends ...VerificationBackend,
+) (time.Time, error) {
+	results, err := t.verifyAll(ctx, orNop(opts.Logger), backends)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var earliest time.Time
+	var lastErr error
+	var unknownTags []string
+	for _, r := range results {
+		switch r.Status {
+		case VerificationConfirmed:
+			if earliest.IsZero() || r.Time.Before(earliest) {
+				earliest = r.Time
+			}
+		case VerificationFailed:
+			lastErr = r.Err
+		case VerificationUnknown:
+			tag := hex.EncodeToString(attestationTag(r.Attestation))
+			unknownTags = append(unknownTags, tag)
+		}
+	}
+	if opts.RejectUnknown && len(unknownTags) > 0 {
+		return time.Time{}, fmt.Errorf(
+			"%w: tags %s",
+			ErrUnknownAttestation, strings.Join(unknownTags, ", "),
+		)
+	}
+	if !earliest.IsZero() {
+		return earliest, nil
+	}
+	if lastErr != nil {
+		return time.Time{}, lastErr
+	}
+	return time.Time{}, ErrIncomplete
+}
+
+// A VerificationStatus is the outcome of verifying one attestation.
+type VerificationStatus int
+
+const (
+	// VerificationConfirmed means the block of the attestation has the
+	// expected merkle root.
+	VerificationConfirmed VerificationStatus = iota
+	// VerificationFailed means the block could not be looked up or has
+	// another merkle root.
+	VerificationFailed
+	// VerificationPending means the attestation is pending and the
+	// timestamp has to be upgraded.
+	VerificationPending
+	// VerificationNoBackend means no backend for the chain of the
+	// attestation was given.
+	VerificationNoBackend
+	// VerificationUnsupported means the attestation is of a known type
+	// that can't be verified, e.g. an ethereum attestation.
+	VerificationUnsupported
+	// VerificationUnknown means the attestation is of an unknown type.
+	VerificationUnknown
+)
+
+func (s VerificationStatus) String() string {
+	switch s {
+	case VerificationConfirmed:
+		return "confirmed"
+	case VerificationFailed:
+		return "failed"
+	case VerificationPending:
+		return "pending"
+	case VerificationNoBackend:
+		return "no backend"
+	case VerificationUnsupported:
+		return "unsupported"
+	case VerificationUnknown:
+		return "unknown"
+	default:
+		return fmt.Sprintf("VerificationStatus(%d)", int(s))
+	}
+}
+
+// A VerificationResult describes the verification of one attestation of a
+// timestamp.
+type VerificationResult struct {
+	Attestation Attestation
+	Status      VerificationStatus
+	// Chain and Height identify the attested block of bitcoin and
+	// litecoin attestations.
+	Chain  Chain
+	Height uint64
+	// MerkleRoot is the message the attestation commits to, in the byte
+	// order of the block header. It is nil for pending and unknown
+	// attestations.
+	MerkleRoot []byte
+	// Time is the UTC time of the block if the attestation is confirmed.
+	Time time.Time
+	// Backend is the backend that was asked for the block, if any, and
+	// Network the network it looked the block up on.
+	Backend VerificationBackend
+	Network Network
+	// Err is the reason verification failed.
+	Err error
+}
+
+// VerifyAll verifies each attestation of t with the first backend for its
+// chain, like Verify, and returns a result for every attestation in the order
+// of WalkAttestations, including the ones that were skipped. An error is only
+// returned if ctx is done.
+func (t *Timestamp) VerifyAll(
+	ctx context.Context, backends ...VerificationBackend,
+) ([]VerificationResult, error) {
+	return t.verifyAll(ctx, nopLogger{}, backends)
+}
+
+func (t *Timestamp) verifyAll(
+	ctx context.Context, log Logger, backends []VerificationBackend,
+) ([]VerificationResult, error) {
+	backendFor := func(chain Chain) VerificationBackend {
+		for _, b := range backends {
+			if backendChain(b) == chain {
+				return b
+			}
+		}
+		return nil
+	}
+	var results []VerificationResult
+	t.WalkAttestations(func(path []Operation, a Attestation) {
+		if ctx.Err() != nil {
+			return
+		}
+		r := VerificationResult{Attestation: a}
+		switch att := a.(type) {
+		case *PendingAttestation:
+			r.Status = VerificationPending
+		case *BitcoinAttestation:
+			r.Chain, r.Height = ChainBitcoin, att.Height()
+			t.verifyResult(ctx, log, &r, path, backendFor(r.Chain))
+		case *LitecoinAttestation:
+			r.Chain, r.Height = ChainLitecoin, att.Height()
+			t.verifyResult(ctx, log, &r, path, backendFor(r.Chain))
+		case *UnknownAttestation:
+			log.Warnf("skipping unknown attestation %v", a)
+			r.Status = VerificationUnknown
+		default:
+			log.Warnf("skipping unsupported attestation %v", a)
+			r.Status = VerificationUnsupported
+		}
+		results = append(results, r)
+	})
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return results, nil
+}
+
+// verifyResult looks up the block of r with backend and sets the status of r.
+func (t *Timestamp) verifyResult(
+	ctx context.Context, log Logger, r *VerificationResult,
+	path []Operation, backend VerificationBackend,
+) {
+	if backend == nil {
+		log.Warnf("no %v backend to verify %v", r.Chain, r.Attestation)
+		r.Status = VerificationNoBackend
+		return
+	}
+	r.Backend, r.Network = backend, backendNetwork(backend)
+	root, err := ComputeResult(t.Message, path)
+	if err != nil {
+		r.Status, r.Err = VerificationFailed, err
+		return
+	}
+	r.MerkleRoot = root
+	attTime, err := verifyBlock(ctx, backend, r.Height, root)
+	if err != nil {
+		log.Warnf("verifying %v failed: %v", r.Attestation, err)
+		r.Status = VerificationFailed
+		r.Err = fmt.Errorf("%v: %w", r.Attestation, err)
+		return
+	}
+	log.Infof(
+		"verified %v block %d on %v", r.Chain, r.Height, r.Network,
+	)
+	r.Status, r.Time = VerificationConfirmed, attTime
+}
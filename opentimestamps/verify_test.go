@@ -0,0 +1,248 @@
+package opentimestamps
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// helloWorldMerkleRoot is the merkle root of bitcoin block 358391 which is
+// attested by examples/hello-world.txt.ots.
+var helloWorldMerkleRoot = mustDecodeHex(
+	"007ee445d23ad061af4a36b809501fab1ac4f2d7e7a739817dd0cbb7ec661b8a",
+)
+
+var helloWorldTime = time.Date(2015, 5, 28, 15, 41, 18, 0, time.UTC)
+
+// testBackend serves block headers from a map.
+type testBackend map[uint64]*BlockHeader
+
+func (b testBackend) BlockHeader(
+	ctx context.Context, height uint64,
+) (*BlockHeader, error) {
+	if h, ok := b[height]; ok {
+		return h, nil
+	}
+	return nil, fmt.Errorf("no block at height %d", height)
+}
+
+func newTestBackend() testBackend {
+	return testBackend{
+		358391: {MerkleRoot: helloWorldMerkleRoot, Time: helloWorldTime},
+	}
+}
+
+func TestVerifyBitcoinAttestation(t *testing.T) {
+	backend := newTestBackend()
+	att := NewBitcoinAttestation(358391)
+
+	attTime, err := VerifyBitcoinAttestation(
+		context.Background(), backend, att, helloWorldMerkleRoot,
+	)
+	require.NoError(t, err)
+	assert.Equal(t, helloWorldTime, attTime)
+
+	_, err = VerifyBitcoinAttestation(
+		context.Background(), backend, att, make([]byte, 32),
+	)
+	assert.True(t, errors.Is(err, ErrMerkleRootMismatch), err)
+
+	_, err = VerifyBitcoinAttestation(
+		context.Background(), backend, NewBitcoinAttestation(1),
+		helloWorldMerkleRoot,
+	)
+	assert.Error(t, err)
+}
+
+// litecoinTestBackend serves litecoin block headers from a map.
+type litecoinTestBackend struct {
+	testBackend
+}
+
+func (litecoinTestBackend) Chain() Chain {
+	return ChainLitecoin
+}
+
+// testnetTestBackend serves testnet block headers from a map.
+type testnetTestBackend struct {
+	testBackend
+}
+
+func (testnetTestBackend) BitcoinNetwork() Network {
+	return NetworkTestnet
+}
+
+func TestTimestampVerify(t *testing.T) {
+	ctx := context.Background()
+	dts, err := NewDetachedTimestampFromPath("../examples/hello-world.txt.ots")
+	require.NoError(t, err)
+	attTime, err := dts.Timestamp.Verify(ctx, newTestBackend())
+	require.NoError(t, err)
+	assert.Equal(t, helloWorldTime, attTime)
+
+	// the bitcoin attestation can't be verified without a bitcoin backend
+	_, err = dts.Timestamp.Verify(ctx, litecoinTestBackend{newTestBackend()})
+	assert.True(t, errors.Is(err, ErrIncomplete), err)
+
+	_, err = dts.Timestamp.Verify(ctx, testBackend{
+		358391: {MerkleRoot: make([]byte, 32), Time: helloWorldTime},
+	})
+	assert.True(t, errors.Is(err, ErrMerkleRootMismatch), err)
+
+	for _, path := range []string{
+		"../examples/incomplete.txt.ots",
+		"../examples/unknown-notary.txt.ots",
+	} {
+		dts, err := NewDetachedTimestampFromPath(path)
+		require.NoError(t, err)
+		_, err = dts.Timestamp.Verify(ctx, newTestBackend())
+		assert.True(t, errors.Is(err, ErrIncomplete), path, err)
+	}
+}
+
+func TestTimestampVerifyLogger(t *testing.T) {
+	ctx := context.Background()
+	log := &recordingLogger{}
+	opts := DefaultVerifyOptions
+	opts.Logger = log
+	dts, err := NewDetachedTimestampFromPath("../examples/hello-world.txt.ots")
+	require.NoError(t, err)
+	_, err = dts.Timestamp.VerifyWithOptions(ctx, opts, newTestBackend())
+	require.NoError(t, err)
+	assert.Equal(t,
+		[]string{"info verified bitcoin block 358391 on mainnet"},
+		log.events,
+	)
+
+	log.events = nil
+	dts, err = NewDetachedTimestampFromPath(
+		"../examples/unknown-notary.txt.ots",
+	)
+	require.NoError(t, err)
+	_, err = dts.Timestamp.VerifyWithOptions(ctx, opts, newTestBackend())
+	assert.True(t, errors.Is(err, ErrIncomplete), err)
+	assert.Len(t, log.matching("warn skipping unknown attestation"), 1)
+}
+
+func TestTimestampVerifySkipUnknown(t *testing.T) {
+	ctx := context.Background()
+	dts, err := NewDetachedTimestampFromPath("../examples/hello-world.txt.ots")
+	require.NoError(t, err)
+	unknown := &UnknownAttestation{
+		tagBytes: []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08},
+		bytes:    []byte("future"),
+	}
+	ts := dts.Timestamp
+	ts.Attestations = append(ts.Attestations, unknown)
+
+	attTime, err := ts.Verify(ctx, newTestBackend())
+	require.NoError(t, err)
+	assert.Equal(t, helloWorldTime, attTime)
+
+	// skipped unless rejected explicitly
+	attTime, err = ts.VerifyWithOptions(
+		ctx, VerifyOptions{Logger: &recordingLogger{}}, newTestBackend(),
+	)
+	require.NoError(t, err)
+	assert.Equal(t, helloWorldTime, attTime)
+	opts := VerifyOptions{RejectUnknown: true}
+	_, err = ts.VerifyWithOptions(ctx, opts, newTestBackend())
+	assert.True(t, errors.Is(err, ErrUnknownAttestation), err)
+	assert.Contains(t, err.Error(), "0102030405060708")
+
+	// the unknown attestation survives verification and serialization
+	var buf bytes.Buffer
+	require.NoError(t, dts.WriteToStream(&buf))
+	parsed, err := NewDetachedTimestampFromReader(&buf)
+	require.NoError(t, err)
+	require.Len(t, parsed.Timestamp.Attestations, 1)
+	assert.True(t, unknown.Equal(parsed.Timestamp.Attestations[0]))
+}
+
+func TestTimestampVerifyEarliest(t *testing.T) {
+	ts := &Timestamp{Message: helloWorldMerkleRoot}
+	ts.Attestations = []Attestation{
+		NewBitcoinAttestation(358391), NewLitecoinAttestation(1000),
+	}
+	earlier := helloWorldTime.Add(-time.Hour)
+	bitcoin := newTestBackend()
+	litecoin := litecoinTestBackend{testBackend{
+		1000: {MerkleRoot: helloWorldMerkleRoot, Time: earlier},
+	}}
+
+	attTime, err := ts.Verify(context.Background(), bitcoin)
+	require.NoError(t, err)
+	assert.Equal(t, helloWorldTime, attTime)
+
+	attTime, err = ts.Verify(context.Background(), bitcoin, litecoin)
+	require.NoError(t, err)
+	assert.Equal(t, earlier, attTime)
+}
+
+func TestTimestampVerifyAll(t *testing.T) {
+	ts := &Timestamp{Message: helloWorldMerkleRoot}
+	unknown := &UnknownAttestation{
+		tagBytes: mustDecodeHex("0102030405060708"), bytes: []byte{0x01},
+	}
+	pending := NewPendingAttestation("https://alice.example.com")
+	ts.Attestations = []Attestation{
+		NewBitcoinAttestation(358391),
+		NewBitcoinAttestation(1),
+		NewLitecoinAttestation(1000),
+		pending,
+		unknown,
+		NewEthereumAttestation(1),
+	}
+	backend := newTestBackend()
+	results, err := ts.VerifyAll(context.Background(), backend)
+	require.NoError(t, err)
+	require.Len(t, results, 6)
+
+	confirmed := results[0]
+	assert.Equal(t, VerificationConfirmed, confirmed.Status)
+	assert.Equal(t, ChainBitcoin, confirmed.Chain)
+	assert.Equal(t, uint64(358391), confirmed.Height)
+	assert.Equal(t, helloWorldMerkleRoot, confirmed.MerkleRoot)
+	assert.Equal(t, helloWorldTime, confirmed.Time)
+	assert.Equal(t, backend, confirmed.Backend)
+	assert.Equal(t, NetworkMainnet, confirmed.Network)
+	assert.NoError(t, confirmed.Err)
+
+	assert.Equal(t, VerificationFailed, results[1].Status)
+	assert.Error(t, results[1].Err)
+	assert.Equal(t, VerificationNoBackend, results[2].Status)
+	assert.Equal(t, ChainLitecoin, results[2].Chain)
+	assert.Equal(t, VerificationPending, results[3].Status)
+	assert.Equal(t, Attestation(pending), results[3].Attestation)
+	assert.Nil(t, results[3].MerkleRoot)
+	assert.Equal(t, VerificationUnknown, results[4].Status)
+	assert.Equal(t, VerificationUnsupported, results[5].Status)
+	assert.Equal(t, "no backend", VerificationNoBackend.String())
+
+	results, err = ts.VerifyAll(
+		context.Background(), testnetTestBackend{backend},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, VerificationConfirmed, results[0].Status)
+	assert.Equal(t, NetworkTestnet, results[0].Network)
+	assert.Equal(t, NetworkTestnet, results[1].Network)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = ts.VerifyAll(ctx, backend)
+	assert.True(t, errors.Is(err, context.Canceled))
+}
+
+func TestReverseHex(t *testing.T) {
+	assert.Equal(t,
+		"8a1b66ecb7cbd07d8139a7e7d7f2c41aab1f5009b8364aaf61d03ad245e47e00",
+		ReverseHex(helloWorldMerkleRoot),
+	)
+	assert.Equal(t, "", ReverseHex(nil))
+}